@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// exit codes by error class
+const (
+	exitOK = iota
+	// calculation failed, e.g. division by zero or argument out of domain
+	exitMath
+	// invalid command, option or input which couldn't be read
+	exitUsage
+	// assertion of a script failed
+	exitAssert
+	// expression couldn't be parsed, e.g. unknown identifier
+	exitSyntax
+)
+
+// exit code of error class
+func exitCode(err error) int {
+	switch errorOf(err).kind {
+	case syntaxError:
+		return exitSyntax
+	case usageError:
+		return exitUsage
+	case assertionError:
+		return exitAssert
+	}
+	return exitMath
+}
+
+// longest accepted input line
+const maxLineLength = 1 << 20
+
+// max nesting of included scripts
+const maxIncludeDepth = 16
+
+var includePattern = regexp.MustCompile(`^include\s+"([^"]+)"$`)
+
+// state of batch evaluation shared by included scripts
+type batchRun struct {
+	code int
+	// names of scripts being evaluated, to detect include loops
+	files []string
+}
+
+// evaluate file line by line, returns exit code
+func batchFile(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		printResult(path, "", output{}, setUsageError(err.Error()))
+		return exitUsage
+	}
+	defer file.Close()
+	return batch(file, path)
+}
+
+// evaluate input statement by statement and print results, variables are
+// shared between statements, returns exit code of the first error
+func batch(r io.Reader, name string) int {
+	b := &batchRun{}
+	b.run(r, name)
+	return b.code
+}
+
+// evaluate script, returns false if evaluation must stop
+func (b *batchRun) run(r io.Reader, name string) bool {
+	b.files = append(b.files, name)
+	defer func() { b.files = b.files[:len(b.files)-1] }()
+
+	statement, start := "", 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if statement == "" {
+			start = n
+		}
+		// statement continues on the next line after \, an unclosed
+		// parenthesis is an error of its own line
+		if strings.HasSuffix(line, "\\") {
+			statement += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		statement = strings.TrimSpace(statement + line)
+		if statement != "" && !b.exec(statement, name, start) {
+			return false
+		}
+		statement = ""
+	}
+	if err := scanner.Err(); err != nil {
+		printResult(name, "", output{}, setUsageError(err.Error()))
+		b.code = exitUsage
+		return false
+	}
+	if statement = strings.TrimSpace(statement); statement != "" {
+		return b.exec(statement, name, start)
+	}
+	return true
+}
+
+// evaluate statement at line n of script name
+func (b *batchRun) exec(statement, name string, n int) bool {
+	if m := includePattern.FindStringSubmatch(statement); m != nil {
+		return b.include(statement, m[1], name, n)
+	}
+	for _, part := range splitStatements(statement) {
+		out, err := calculate(part)
+		if err != nil {
+			if !b.report(part, err, name, n) {
+				return false
+			}
+			continue
+		}
+		printResult(part, "", out, nil)
+	}
+	return true
+}
+
+// print error of statement at line n, returns false if evaluation must stop
+func (b *batchRun) report(statement string, err error, name string, n int) bool {
+	printResult(statement, fmt.Sprintf("%s:%d: ", name, n), output{}, err)
+	// exit code is the class of the first error, failed assertion stops
+	// evaluation and always sets its own code
+	if errorOf(err).kind == assertionError {
+		b.code = exitAssert
+		return false
+	}
+	if b.code == exitOK {
+		b.code = exitCode(err)
+	}
+	return true
+}
+
+// evaluate script with path relative to the including script
+func (b *batchRun) include(statement, path, from string, n int) bool {
+	if !filepath.IsAbs(path) && from != "stdin" {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	if len(b.files) >= maxIncludeDepth {
+		return b.report(statement, setUsageError("include: too deep nesting"), from, n)
+	}
+	for _, name := range b.files {
+		if name == path {
+			return b.report(statement, setUsageError("include: "+path+" includes itself"), from, n)
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return b.report(statement, setUsageError("include: "+err.Error()), from, n)
+	}
+	defer file.Close()
+	return b.run(file, path)
+}
+
+// strip # comment outside of quotes
+func stripComment(line string) string {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '#' && !quoted:
+			return line[:i]
+		}
+	}
+	return line
+}
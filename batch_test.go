@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// run f with stdout and stderr captured
+func captureOutput(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	stdout, stderr := os.Stdout, os.Stderr
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pipes get no colors
+	savedOut, savedErr := outColors, errColors
+	os.Stdout, os.Stderr = outW, errW
+	csvWriter = csv.NewWriter(outW)
+	outColors, errColors = renderer{}, renderer{}
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		csvWriter = csv.NewWriter(stdout)
+		outColors, errColors = savedOut, savedErr
+	}()
+
+	read := func(r io.Reader, text chan<- string) {
+		b, _ := io.ReadAll(r)
+		text <- string(b)
+	}
+	outText, errText := make(chan string), make(chan string)
+	go read(outR, outText)
+	go read(errR, errText)
+	f()
+	outW.Close()
+	errW.Close()
+	return <-outText, <-errText
+}
+
+// reset state shared between statements of a run
+func resetVariables() {
+	variables = map[string]Value{}
+}
+
+var batchTests = []struct {
+	in   string
+	out  string
+	code int
+}{
+	{"1 + 1\n2 * 3\n", "2\n6\n", exitOK},
+	// variables are shared between lines, assignments are silent
+	{"x = 2\nx^10\n", "1024\n", exitOK},
+	{"x = 2; y = 3\nx * y\n", "6\n", exitOK},
+	{"\n   \n7\n", "7\n", exitOK},
+	// evaluation continues after errors, exit code is the first error class
+	{"1 / 0\n2 +\n5\n", "5\n", exitMath},
+	{"2 +\n1 / 0\n", "", exitSyntax},
+	{"--nosuchoption\n", "", exitUsage},
+	// unclosed parenthesis doesn't join the following lines
+	{"1+1\nsin(1\n2+2\n3+3\n", "2\n4\n6\n", exitSyntax},
+	{"print \"(%d\", 5\n2+2\n", "(5\n4\n", exitOK},
+	// last line without newline
+	{"3 * 3", "9\n", exitOK},
+}
+
+func TestBatch(t *testing.T) {
+	for _, test := range batchTests {
+		resetVariables()
+		var code int
+		out, _ := captureOutput(t, func() {
+			code = batch(strings.NewReader(test.in), "stdin")
+		})
+		if out != test.out || code != test.code {
+			t.Errorf("batch %q = %q, code %d, expected %q, code %d", test.in, out, code, test.out, test.code)
+		}
+	}
+}
+
+func TestBatchErrorLocation(t *testing.T) {
+	resetVariables()
+	_, errText := captureOutput(t, func() {
+		batch(strings.NewReader("1\n\n1 / 0\nsin(1\n2\n"), "stdin")
+	})
+	for _, where := range []string{"stdin:3: error: ", "stdin:4: error: "} {
+		if !strings.Contains(errText, where) {
+			t.Errorf("error %q, expected location %s", errText, where)
+		}
+	}
+}
+
+func TestBatchFile(t *testing.T) {
+	resetVariables()
+	var code int
+	_, errText := captureOutput(t, func() {
+		code = batchFile("testdata/nosuchfile.icalc")
+	})
+	if code != exitUsage || errText == "" {
+		t.Errorf("missing file: code %d, error %q, expected code %d", code, errText, exitUsage)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// max number of terms of sum and prod
+const maxTerms = 1000000
+
+// requested accuracy and max subintervals of integrate
+const (
+	integrateTolerance = 1e-13
+	maxSubintervals    = 2000
+)
+
+func init() {
+	forms["integrate"] = form{4, 4, integrate, "integrate(f, x, a, b)", "definite integral of f by x from a to b"}
+	forms["sum"] = form{4, 4, sumForm, "sum(f, k, a, b)", "sum of f for integer k from a to b"}
+	forms["prod"] = form{4, 4, prodForm, "prod(f, k, a, b)", "product of f for integer k from a to b"}
+}
+
+// bound variable and range of sum, prod and integrate
+func boundArgs(name string, args []Node) (string, Value, Value, error) {
+	x, ok := args[1].(*identNode)
+	if !ok {
+		return "", Value{}, Value{}, setError(name + ": second argument must be a variable")
+	}
+	from, err := eval(args[2])
+	if err != nil {
+		return "", Value{}, Value{}, err
+	}
+	to, err := eval(args[3])
+	if err != nil {
+		return "", Value{}, Value{}, err
+	}
+	if from.symbolic || to.symbolic {
+		return "", Value{}, Value{}, setError(name + ": bounds must be numbers")
+	}
+	return x.name, from, to, nil
+}
+
+func sumForm(args []Node) (Value, error) {
+	return iterate("sum", args, int64Value(0), addValues)
+}
+
+func prodForm(args []Node) (Value, error) {
+	return iterate("prod", args, int64Value(1), multiplyValues)
+}
+
+// combine values of f for k from a to b
+func iterate(name string, args []Node, result Value, combine func(a, b Value) Value) (Value, error) {
+	k, from, to, err := boundArgs(name, args)
+	if err != nil {
+		return Value{}, err
+	}
+	a, okA := from.Int()
+	b, okB := to.Int()
+	if !okA || !okB {
+		return Value{}, setError(name + ": bounds must be integers")
+	}
+	count := new(big.Int).Sub(b, a)
+	if count.Cmp(big.NewInt(maxTerms)) >= 0 {
+		return Value{}, setError(name + ": more than " + strconv.Itoa(maxTerms) + " terms")
+	}
+	for i := new(big.Int).Set(a); i.Cmp(b) <= 0; i.Add(i, bigOne) {
+		v, err := evalWith(args[0], k, intValue(new(big.Int).Set(i)))
+		if err != nil {
+			return v, err
+		}
+		if v.symbolic {
+			return Value{}, setError(name + ": unknown identifier in " + v.String())
+		}
+		result = combine(result, v)
+	}
+	return result, nil
+}
+
+func integrate(args []Node) (Value, error) {
+	x, from, to, err := boundArgs("integrate", args)
+	if err != nil {
+		return Value{}, err
+	}
+	a, b := from.Float(), to.Float()
+	if math.IsInf(a, 0) || math.IsInf(b, 0) || math.IsNaN(a) || math.IsNaN(b) {
+		return Value{}, setError("integrate: bounds must be finite")
+	}
+
+	var evalErr error
+	f := func(t float64) float64 {
+		v, err := evalWith(args[0], x, floatValue(t))
+		if err == nil && v.symbolic {
+			err = setError("integrate: unknown identifier in " + v.String())
+		}
+		if err != nil {
+			if evalErr == nil {
+				evalErr = err
+			}
+			return 0
+		}
+		return v.Float()
+	}
+
+	sign := 1.0
+	if a > b {
+		a, b, sign = b, a, -1
+	}
+	res, estimate := adaptiveQuadrature(f, a, b)
+	if evalErr != nil {
+		return Value{}, evalErr
+	}
+	v := floatValue(sign * res)
+	v.uncertainty = estimate
+	return v, nil
+}
+
+// Gauss-Kronrod 7-15 nodes and weights on [-1, 1]
+var (
+	kronrodNodes = [8]float64{
+		0.991455371120812639206854697526329, 0.949107912342758524526189684047851,
+		0.864864423359769072789712788640926, 0.741531185599394439863864773280788,
+		0.586087235467691130294144845693013, 0.405845151377397166906606412076961,
+		0.207784955007898467600689403773245, 0,
+	}
+	kronrodWeights = [8]float64{
+		0.022935322010529224963732008058970, 0.063092092629978553290700663189204,
+		0.104790010322250183839876322541518, 0.140653259715525918745189590510238,
+		0.169004726639267902826583426598550, 0.190350578064785409913256402421014,
+		0.204432940075298892414161999234649, 0.209482141084727828012999174891714,
+	}
+	gaussWeights = [4]float64{
+		0.129484966168869693270611432679082, 0.279705391489276667901467771423780,
+		0.381830050505118944950369775488975, 0.417959183673469387755102040816327,
+	}
+)
+
+// integral of f on [a, b] and its error estimate by Gauss-Kronrod rule
+func gaussKronrod(f func(float64) float64, a, b float64) (float64, float64) {
+	c, h := (a+b)/2, (b-a)/2
+	fc := f(c)
+	kronrod := fc * kronrodWeights[7]
+	gauss := fc * gaussWeights[3]
+	for j := 0; j < 7; j++ {
+		x := h * kronrodNodes[j]
+		pair := f(c-x) + f(c+x)
+		kronrod += kronrodWeights[j] * pair
+		if j%2 == 1 {
+			gauss += gaussWeights[j/2] * pair
+		}
+	}
+	return kronrod * h, math.Abs(kronrod-gauss) * h
+}
+
+// subinterval of adaptive quadrature
+type segment struct {
+	a, b, value, estimate float64
+}
+
+// segments ordered by largest error estimate first
+type segments []segment
+
+func (s segments) Len() int            { return len(s) }
+func (s segments) Less(i, j int) bool  { return s[i].estimate > s[j].estimate }
+func (s segments) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *segments) Push(x interface{}) { *s = append(*s, x.(segment)) }
+func (s *segments) Pop() interface{} {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
+
+// bisect the segment with the largest error until the total error is
+// small enough, returns integral and its error estimate
+func adaptiveQuadrature(f func(float64) float64, a, b float64) (float64, float64) {
+	if a == b {
+		return 0, 0
+	}
+	value, estimate := gaussKronrod(f, a, b)
+	queue := &segments{{a, b, value, estimate}}
+	for queue.Len() < maxSubintervals {
+		if estimate <= math.Max(integrateTolerance, integrateTolerance*math.Abs(value)) {
+			break
+		}
+		s := heap.Pop(queue).(segment)
+		m := (s.a + s.b) / 2
+		if m <= s.a || m >= s.b {
+			// segment can't be split any further
+			heap.Push(queue, s)
+			break
+		}
+		left, leftEstimate := gaussKronrod(f, s.a, m)
+		right, rightEstimate := gaussKronrod(f, m, s.b)
+		heap.Push(queue, segment{s.a, m, left, leftEstimate})
+		heap.Push(queue, segment{m, s.b, right, rightEstimate})
+		value += left + right - s.value
+		estimate += leftEstimate + rightEstimate - s.estimate
+	}
+
+	// sum again to avoid accumulated rounding of updates
+	value, estimate = 0, 0
+	for _, s := range *queue {
+		value += s.value
+		estimate += s.estimate
+	}
+	return value, estimate
+}
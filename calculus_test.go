@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+var iterateTests = []struct {
+	in, out string
+}{
+	{"sum(k, k, 1, 100)", "5050"},
+	{"sum(k^2, k, -3, 3)", "28"},
+	{"sum(k, k, 5, 1)", "0"},
+	{"prod(k, k, 1, 20)", "2432902008176640000"},
+	{"prod(k, k, 1, 0)", "1"},
+	// exact beyond int64
+	{"prod(2, k, 1, 100)", "1267650600228229401496703205376"},
+	{"sum(1/2^k, k, 1, 3)", "0.875"},
+}
+
+func TestSumProd(t *testing.T) {
+	resetVariables()
+	for _, test := range iterateTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.in, err)
+			continue
+		}
+		if res.String() != test.out {
+			t.Errorf("%s = %s, expected %s", test.in, res, test.out)
+		}
+	}
+}
+
+var integrateTests = []struct {
+	in   string
+	want float64
+}{
+	{"integrate(x^2, x, 0, 3)", 9},
+	{"integrate(x, x, 1, 0)", -0.5},
+	{"integrate(sin(x), x, 0, pi)", 2},
+	{"integrate(exp(-x^2), x, -10, 10)", math.Sqrt(math.Pi)},
+	// singularity at the bound
+	{"integrate(1/sqrt(x), x, 0, 1)", 2},
+}
+
+func TestIntegrate(t *testing.T) {
+	resetVariables()
+	for _, test := range integrateTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.in, err)
+			continue
+		}
+		if math.Abs(res.Float()-test.want) > 1e-9 {
+			t.Errorf("%s = %v, expected %v", test.in, res, test.want)
+		}
+	}
+}
+
+var calculusErrorTests = []string{
+	"sum(k, k, 1, 10^7)",
+	"sum(k, k, 0.5, 2)",
+	"sum(k*y, k, 1, 2)",
+	"prod(k, k, 1, y)",
+	"integrate(x, 2, 0, 1)",
+}
+
+func TestCalculusErrors(t *testing.T) {
+	resetVariables()
+	for _, in := range calculusErrorTests {
+		if _, err := evaluate(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command line option, short is an alias of name
+type option struct {
+	short, name, arg, help string
+}
+
+// options in order of help
+var options []option
+
+var flags = flag.NewFlagSet("icalc", flag.ContinueOnError)
+
+// values of options
+var (
+	// info printed instead of calculation, e.g. --help
+	info string
+	// file evaluated in batch mode
+	batchPath string
+	// file with default options
+	configPath string
+	// address of serve subcommand
+	serveAddr = "localhost:8080"
+)
+
+// subcommand, usage and help
+var subcommands = [][3]string{
+	{"eval", "eval <statement>...", "evaluate statements, the default with arguments"},
+	{"repl", "repl", "interactive mode, the default without arguments"},
+	{"serve", "serve", "evaluate expressions of http requests: GET /eval?expr=1%2B1"},
+}
+
+// error of the last option value, flag package returns it as plain text
+var optionError error
+
+// option with value
+type funcFlag func(string) error
+
+func (f funcFlag) String() string { return "" }
+func (f funcFlag) Set(s string) error {
+	optionError = f(s)
+	return optionError
+}
+
+// option without value, e.g. --quiet
+type boolFlag func()
+
+func (f boolFlag) String() string   { return "" }
+func (f boolFlag) IsBoolFlag() bool { return true }
+func (f boolFlag) Set(s string) error {
+	if on, err := strconv.ParseBool(s); err != nil || !on {
+		optionError = setUsageError("option doesn't take a value")
+		return optionError
+	}
+	f()
+	return nil
+}
+
+func init() {
+	addOption(boolFlag(func() { info = helpText() }), "h", "help", "", "for more information about a commands")
+	addOption(boolFlag(func() { info = operatorsInfo }), "o", "operators", "", "list of supported operators")
+	addOption(boolFlag(func() { info = functionsInfo() }), "", "functions", "", "list of supported functions")
+	addOption(funcFlag(func(s string) error { batchPath = s; return nil }), "f", "file", "<file>", "evaluate statements of file line by line")
+	addOption(funcFlag(setPrecisionOption), "p", "precision", "<n>", "round results to n decimal places, -1 for full precision")
+	addOption(funcFlag(setNumberFormat), "", "format", "<format>", "number format: auto, fixed or sci")
+	addOption(funcFlag(setNumberBase), "", "base", "<base>", "base of integer results: 2, 8, 10 or 16")
+	addOption(funcFlag(setAngleUnit), "", "angle", "<unit>", "angle unit of trigonometric functions: rad or deg")
+	addOption(funcFlag(setOutputFormat), "", "output", "<format>", "print results as text, json or csv")
+	addOption(funcFlag(setColorMode), "", "color", "<mode>", "colors: auto for terminals without NO_COLOR set, always or never")
+	addOption(boolFlag(func() { colorMode = colorNever }), "", "no-color", "", "print without colors, same as --color never")
+	addOption(funcFlag(setTheme), "", "theme", "<name>", "colors of interactive mode: dark, light or monochrome")
+	addOption(funcFlag(func(s string) error { configPath = s; return nil }), "", "config", "<file>", "read settings from TOML or JSON file, ~/.config/icalc/config.toml by default")
+	addOption(boolFlag(func() { quiet = true }), "q", "quiet", "", "print results only, failures are reported by exit code")
+	addOption(boolFlag(func() { printAll = true }), "", "all", "", "print results of all statements and assignments, not only the last one")
+	addOption(funcFlag(func(s string) error { serveAddr = s; return nil }), "", "addr", "<host:port>", "address of serve, "+serveAddr+" by default")
+
+	// errors are reported by main
+	flags.SetOutput(nopWriter{})
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func addOption(v flag.Value, short, name, arg, help string) {
+	flags.Var(v, name, help)
+	if short != "" {
+		flags.Var(v, short, help)
+	}
+	options = append(options, option{short, name, arg, help})
+}
+
+func setPrecisionOption(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return setUsageError("precision must be an integer from -1 to " + strconv.Itoa(maxPrecision))
+	}
+	_, err = setPrecision([]Value{int64Value(int64(n))})
+	return err
+}
+
+// parse options before statements, returns the subcommand and the rest of
+// arguments; settings of the config file and environment are loaded once
+// between two passes over the options so the command line overrides them
+func parseArgs(args []string) (string, []string, error) {
+	if _, _, err := parseOptions(args); err != nil {
+		return "", nil, err
+	}
+	if err := loadSettings(); err != nil {
+		return "", nil, err
+	}
+	return parseOptions(args)
+}
+
+// options may follow subcommand too, e.g. serve --addr :8080
+func parseOptions(args []string) (string, []string, error) {
+	if err := parseFlags(markStatements(args)); err != nil {
+		return "", nil, err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		return "", args, nil
+	}
+	for _, cmd := range subcommands {
+		if args[0] == cmd[0] {
+			if err := parseFlags(markStatements(args[1:])); err != nil {
+				return "", nil, err
+			}
+			return cmd[0], flags.Args(), nil
+		}
+	}
+	return "", args, nil
+}
+
+func parseFlags(args []string) error {
+	optionError = nil
+	if err := flags.Parse(args); err != nil {
+		if optionError != nil {
+			return optionError
+		}
+		return setUsageError(err.Error())
+	}
+	return nil
+}
+
+// end options before the first argument which isn't an option, e.g. -2+3 or -pi
+func markStatements(args []string) []string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-") {
+			return args
+		}
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		f := flags.Lookup(name)
+		if f == nil {
+			return append(append(append([]string{}, args[:i]...), "--"), args[i:]...)
+		}
+		// skip value of option
+		if _, isBool := f.Value.(boolFlag); !isBool && !strings.Contains(arg, "=") {
+			i++
+		}
+	}
+	return args
+}
+
+// help built from the options, subcommands and commands tables
+func helpText() string {
+	res := headInfo + `
+For interactive mode run icalc without arguments.
+Statements piped to icalc are evaluated line by line.
+
+Options:
+`
+	for _, o := range options {
+		usage := "--" + o.name
+		if o.short != "" {
+			usage = "-" + o.short + ", " + usage
+		}
+		if o.arg != "" {
+			usage += " " + o.arg
+		}
+		res += helpLine(usage, o.help)
+	}
+	res += "\nSubcommands:\n"
+	for _, cmd := range subcommands {
+		res += helpLine(cmd[1], cmd[2])
+	}
+	return res + interactiveInfo + replCommandsList() + argCommandsInfo()
+}
+
+// timeouts of serve connections, slow clients don't hold them forever
+const (
+	serveReadTimeout  = 10 * time.Second
+	serveWriteTimeout = 60 * time.Second
+)
+
+// evaluate statements of http requests, variables are shared between them
+func serve() int {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/eval", func(w http.ResponseWriter, r *http.Request) {
+		expr := r.FormValue("expr")
+		res := serveEval(expr)
+		w.Header().Set("Content-Type", "application/json")
+		if res.Error != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	})
+	server := &http.Server{
+		Addr:              serveAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+	}
+	fmt.Println("Listening on http://" + serveAddr)
+	err := server.ListenAndServe()
+	printResult("serve", "", output{}, setUsageError(err.Error()))
+	return exitUsage
+}
+
+// result of the last statement of expr or the first error
+func serveEval(expr string) (res jsonResult) {
+	calcLock.Lock()
+	defer calcLock.Unlock()
+	// failure of a calculation fails its request, not the server
+	defer func() {
+		if r := recover(); r != nil {
+			res = jsonOf(expr, output{}, setError(fmt.Sprint("internal error: ", r)))
+		}
+	}()
+
+	statements := splitStatements(expr)
+	if len(statements) == 0 {
+		statements = []string{""}
+	}
+	for _, statement := range statements {
+		out, err := calculate(statement)
+		res = jsonOf(expr, out, err)
+		if err != nil {
+			break
+		}
+	}
+	return res
+}
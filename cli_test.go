@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCheckCommands(t *testing.T) {
+	// built after functions are registered
+	tests := []struct {
+		in, out string
+	}{
+		{"--help", helpText()},
+		{"-o", operatorsInfo},
+		{"--functions", functionsInfo()},
+		// -f is --file, not --functions
+		{"-f", "Command not found"},
+		{"--nosuchoption", "Command not found"},
+	}
+	for _, test := range tests {
+		if out := checkCommands(test.in); out != test.out {
+			t.Errorf("checkCommands(%s) = %.40q..., expected %.40q...", test.in, out, test.out)
+		}
+	}
+}
+
+func TestServeEval(t *testing.T) {
+	resetVariables()
+	res := serveEval("x = 2; x^10")
+	if res.Error != nil || res.Result == nil || *res.Result != "1024" {
+		t.Errorf("serveEval = %+v, expected result 1024", res)
+	}
+	res = serveEval("1 / 0")
+	if res.Error == nil || res.Error.Kind != mathError {
+		t.Errorf("serveEval(1 / 0) = %+v, expected math error", res)
+	}
+}
+
+func TestServeEvalPanic(t *testing.T) {
+	functions["crash"] = function{0, 0, func([]Value) (Value, error) { panic("crash") }, "crash()", ""}
+	defer delete(functions, "crash")
+
+	res := serveEval("crash()")
+	if res.Error == nil || res.Error.Msg != "internal error: crash" {
+		t.Errorf("serveEval(crash()) = %+v, expected internal error", res)
+	}
+	// lock is released after the panic
+	res = serveEval("1 + 1")
+	if res.Error != nil || *res.Result != "2" {
+		t.Errorf("serveEval after panic = %+v, expected result 2", res)
+	}
+}
+
+func TestReplWithoutTerminal(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	var code int
+	_, errText := captureOutput(t, func() {
+		code = interactive()
+	})
+	if code != exitUsage || errText != "error: repl needs a terminal\n" {
+		t.Errorf("repl: code %d, error %q, expected usage error", code, errText)
+	}
+}
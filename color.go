@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+
+	"./terminal"
+)
+
+// modes of --color
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+var colorMode = colorAuto
+
+// styles text with escape codes, text is plain if escape is nil
+type renderer struct {
+	escape *terminal.EscapeCodes
+}
+
+// renderers of stdout and stderr, chosen by setupColors
+var outColors, errColors = renderer{terminal.DefaultEscapeCodes()}, renderer{terminal.DefaultEscapeCodes()}
+
+// set --color mode from option
+func setColorMode(mode string) error {
+	switch mode {
+	case colorAuto, colorAlways, colorNever:
+		colorMode = mode
+		return nil
+	}
+	return setUsageError("unknown color mode " + mode + ", expected auto, always or never")
+}
+
+// choose renderers by --color, NO_COLOR and whether output is a terminal
+func setupColors() {
+	colorDepth = detectColorDepth()
+	outColors = colorsFor(int(os.Stdout.Fd()))
+	errColors = colorsFor(int(os.Stderr.Fd()))
+}
+
+func colorsFor(fd int) renderer {
+	switch colorMode {
+	case colorAlways:
+		return renderer{terminal.DefaultEscapeCodes()}
+	case colorNever:
+		return renderer{}
+	}
+	if os.Getenv("NO_COLOR") != "" || !terminal.IsTerminal(fd) {
+		return renderer{}
+	}
+	return renderer{terminal.DefaultEscapeCodes()}
+}
+
+func (r renderer) style(code []byte, text string) string {
+	if r.escape == nil {
+		return text
+	}
+	return string(code) + text + string(r.escape.Reset)
+}
+
+// text in foreground color, e.g. RED
+func (r renderer) fg(color int, text string) string {
+	if r.escape == nil {
+		return text
+	}
+	e := r.escape
+	return r.style([][]byte{e.Black, e.Red, e.Green, e.Yellow, e.Blue, e.Magenta, e.Cyan, e.White}[color], text)
+}
+
+func (r renderer) bold(text string) string {
+	if r.escape == nil {
+		return text
+	}
+	return r.style(r.escape.Bold, text)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"./terminal"
+)
+
+// completion of a name, suffix is added after it, e.g. "(" after functions
+type completion struct {
+	name, suffix string
+}
+
+// Tab completion of interactive mode for term, names of functions, constants,
+// variables and commands are completed, a list of candidates is shown if the
+// name is ambiguous
+func completer(term *terminal.Terminal) func(line []byte, pos, key int) ([]byte, int) {
+	return func(line []byte, pos, key int) ([]byte, int) {
+		if key != '\t' {
+			return nil, 0
+		}
+		start := pos
+		for start > 0 && isIdentChar(line[start-1]) {
+			start--
+		}
+		prefix := string(line[start:pos])
+		if prefix == "" {
+			return nil, 0
+		}
+		candidates := completions(string(line[:start]), prefix)
+		if len(candidates) == 0 {
+			return nil, 0
+		}
+
+		text := candidates[0].name
+		if len(candidates) == 1 {
+			text += candidates[0].suffix
+			// ( of a call typed already
+			if text != candidates[0].name && strings.HasPrefix(string(line[pos:]), candidates[0].suffix) {
+				text = candidates[0].name
+			}
+		} else {
+			for _, c := range candidates[1:] {
+				text = commonPrefix(text, c.name)
+			}
+			if text == prefix {
+				names := make([]string, len(candidates))
+				for i, c := range candidates {
+					names[i] = c.name
+				}
+				term.ShowCompletions(names)
+				return nil, 0
+			}
+		}
+		newLine := append(append(append([]byte(nil), line[:start]...), text...), line[pos:]...)
+		return newLine, start + len(text)
+	}
+}
+
+// sorted completions of prefix, before is the text of the line before it;
+// commands are completed only at the start of the line
+func completions(before, prefix string) []completion {
+	seen := map[string]bool{}
+	var list []completion
+	add := func(name, suffix string) {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			list = append(list, completion{name, suffix})
+		}
+	}
+
+	switch strings.TrimSpace(before) {
+	case ":":
+		for name := range replCommands {
+			add(name, " ")
+		}
+		return sortCompletions(list)
+	case "":
+		for name := range lineCommands {
+			add(name, " ")
+		}
+		for name := range interactiveCommands {
+			add(name, "")
+		}
+	}
+	for name := range functions {
+		add(name, "(")
+	}
+	for name := range forms {
+		add(name, "(")
+	}
+	for name := range argCommands {
+		add(name, "(")
+	}
+	for name := range constants {
+		add(name, "")
+	}
+	// a preview may set variables meanwhile, e.g. parameters of sum
+	calcLock.Lock()
+	for name := range variables {
+		add(name, "")
+	}
+	calcLock.Unlock()
+	return sortCompletions(list)
+}
+
+func sortCompletions(list []completion) []completion {
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+func commonPrefix(a, b string) string {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"./terminal"
+)
+
+// prefix of environment variables overriding the config, e.g. ICALC_PRECISION=2
+const envPrefix = "ICALC_"
+
+// settings of the config file and environment which aren't options
+var settings = map[string]func(string) error{
+	"history-size":    setHistorySize,
+	"iteration-limit": setIterationLimit,
+	"history-file":    setHistoryPath,
+	"edit-mode":       setEditMode,
+}
+
+// sections of the config file, keys are names of their elements
+var sections = map[string]func(key, value string) error{
+	"theme":     setThemeStyle,
+	"keys":      bindKey,
+	"constants": defineConstant,
+	"functions": defineFunction,
+}
+
+// max number of lines in history of interactive mode
+var historySize = terminal.DefaultHistorySize
+
+// keys acting as other keys in interactive mode, e.g. ctrl-p = up
+var keyBindings = map[int]int{}
+
+// line editing keys of interactive mode, emacs or vi
+var editMode = "emacs"
+
+func init() {
+	replCommands["set"] = replCommand{setCommand, ":set vi | emacs | <name> <value>", "switch editing mode or change a setting"}
+}
+
+// setting of the config file or environment
+type setting struct {
+	name, value string
+	// location of the setting for errors, e.g. "config.toml:3"
+	where string
+}
+
+// apply the config file and the environment, the file given by --config or
+// ICALC_CONFIG must exist, the default one is optional
+func loadSettings() error {
+	path := configPath
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	if path != "" {
+		if err := loadConfig(path); err != nil {
+			return err
+		}
+	}
+	return applySettings(envSettings())
+}
+
+// config.toml or config.json in the user config directory, e.g.
+// ~/.config/icalc, empty if there is none
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range []string{"config.toml", "config.json"} {
+		path := filepath.Join(dir, "icalc", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// read settings of a TOML or, if the name ends with .json, JSON file
+func loadConfig(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return setUsageError("config: " + err.Error())
+	}
+	defer file.Close()
+
+	var list []setting
+	if strings.HasSuffix(path, ".json") {
+		list, err = parseJSONConfig(file, path)
+	} else {
+		list, err = parseTOMLConfig(file, path)
+	}
+	if err != nil {
+		return err
+	}
+	return applySettings(list)
+}
+
+// parse name = value lines of TOML, [section] prefixes names of the
+// following lines with "section.", # starts a comment
+func parseTOMLConfig(file *os.File, path string) ([]setting, error) {
+	var list []setting
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		where := path + ":" + strconv.Itoa(n)
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, ok := sections[section]; !ok {
+				return nil, setUsageError(where + ": unknown section " + section)
+			}
+			continue
+		}
+		pair := strings.SplitN(line, "=", 2)
+		if len(pair) != 2 {
+			return nil, setUsageError(where + ": expected name = value")
+		}
+		name := unquote(strings.TrimSpace(pair[0]))
+		if section != "" {
+			name = section + "." + name
+		}
+		list = append(list, setting{name, unquote(strings.TrimSpace(pair[1])), where})
+	}
+	return list, scanner.Err()
+}
+
+// string of a quoted TOML value, other values are taken as they are
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return s[1 : len(s)-1]
+	}
+	if v, err := strconv.Unquote(s); err == nil && strings.HasPrefix(s, `"`) {
+		return v
+	}
+	return s
+}
+
+// parse JSON object of settings, objects inside of it are sections
+func parseJSONConfig(file *os.File, path string) ([]setting, error) {
+	var config map[string]interface{}
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return nil, setUsageError(path + ": " + err.Error())
+	}
+	var list []setting
+	for name, value := range config {
+		if section, ok := value.(map[string]interface{}); ok {
+			for key, v := range section {
+				list = append(list, setting{name + "." + key, jsonText(v), path})
+			}
+			continue
+		}
+		list = append(list, setting{name, jsonText(value), path})
+	}
+	// objects have no order, sorted names keep results repeatable
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list, nil
+}
+
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		// plain decimal, the parser doesn't read exponents
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	text, _ := json.Marshal(v)
+	return string(text)
+}
+
+// settings of ICALC_* variables, e.g. ICALC_THEME_PROMPT is theme.prompt,
+// variables which aren't settings are ignored, they may belong to other tools
+func envSettings() []setting {
+	var list []setting
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		if !strings.HasPrefix(pair[0], envPrefix) || pair[0] == envPrefix+"CONFIG" {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(pair[0], envPrefix))
+		for section := range sections {
+			if strings.HasPrefix(name, section+"_") {
+				name = section + "." + name[len(section)+1:]
+			}
+		}
+		if !isSetting(name) {
+			continue
+		}
+		list = append(list, setting{name, pair[1], pair[0]})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// apply settings in order, errors are reported with their location
+func applySettings(list []setting) error {
+	for _, s := range list {
+		if err := applySetting(s.name, s.value); err != nil {
+			return setUsageError(s.where + ": " + strings.TrimPrefix(err.Error(), "error: "))
+		}
+	}
+	return nil
+}
+
+// check if name is an option, setting or element of a known section
+func isSetting(name string) bool {
+	if i := strings.Index(name, "."); i >= 0 {
+		_, ok := sections[name[:i]]
+		return ok
+	}
+	name = strings.Replace(name, "_", "-", -1)
+	if _, ok := settings[name]; ok {
+		return true
+	}
+	return name != "config" && flags.Lookup(name) != nil
+}
+
+// set option, setting or element of a section, _ and - are the same in names
+// of options, e.g. history_size is history-size
+func applySetting(name, value string) error {
+	if i := strings.Index(name, "."); i >= 0 {
+		set, ok := sections[name[:i]]
+		if !ok {
+			return setUsageError("unknown section " + name[:i])
+		}
+		return set(name[i+1:], value)
+	}
+	name = strings.Replace(name, "_", "-", -1)
+	if set, ok := settings[name]; ok {
+		return set(value)
+	}
+	if name == "config" || flags.Lookup(name) == nil {
+		return setUsageError("unknown option " + name)
+	}
+	return flags.Set(name, value)
+}
+
+func setHistorySize(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return setUsageError("history size must be a positive integer")
+	}
+	historySize = n
+	return nil
+}
+
+func setIterationLimit(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return setUsageError("iteration limit must be a positive integer")
+	}
+	iterationLimit = n
+	return nil
+}
+
+func setEditMode(mode string) error {
+	if mode != "emacs" && mode != "vi" {
+		return setUsageError("unknown edit mode " + mode + ", expected emacs or vi")
+	}
+	editMode = mode
+	return nil
+}
+
+// :set vi switches editing mode, :set precision 3 changes a setting like a
+// line of the config file
+func setCommand(args string, term *terminal.Terminal) (string, error) {
+	name, value := "edit-mode", args
+	if args != "vi" && args != "emacs" {
+		i := strings.IndexAny(args, " =")
+		if i < 0 {
+			return "", setUsageError("expected :set vi, :set emacs or :set <name> <value>")
+		}
+		name, value = args[:i], unquote(strings.TrimSpace(strings.TrimLeft(args[i:], " =")))
+	}
+	if err := applySetting(name, value); err != nil {
+		return "", err
+	}
+	configureTerminal(term)
+	return name + " = " + value, nil
+}
+
+// apply settings of interactive mode to the terminal
+func configureTerminal(term *terminal.Terminal) {
+	term.SetPrompt(promptText())
+	term.SetHistorySize(historySize)
+	term.SetViMode(editMode == "vi")
+	for key, as := range keyBindings {
+		term.BindKey(key, as)
+	}
+}
+
+// make key act as another one in interactive mode, e.g. ctrl-p = up
+func bindKey(key, as string) error {
+	from, ok := terminal.KeyByName(key)
+	if !ok {
+		return setUsageError("unknown key " + key)
+	}
+	to, ok := terminal.KeyByName(as)
+	if !ok {
+		return setUsageError("unknown key " + as)
+	}
+	keyBindings[from] = to
+	return nil
+}
+
+// constant with the value of an expression, e.g. g = 9.80665
+func defineConstant(name, expr string) error {
+	if !identPattern.MatchString(name) {
+		return setUsageError("invalid constant name " + name)
+	}
+	v, err := evaluate(expr)
+	if err != nil {
+		return setUsageError("constant " + name + ": " + strings.TrimPrefix(err.Error(), "error: "))
+	}
+	constants[name] = v
+	return nil
+}
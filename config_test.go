@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openConfig(t *testing.T, name, text string) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestParseTOMLConfig(t *testing.T) {
+	file := openConfig(t, "config.toml", `# settings
+precision = 4
+angle = "deg" # comment
+history_size = 500
+
+[theme]
+prompt = 'bold white'
+
+[functions]
+"hyp(a, b)" = "sqrt(a^2 + b^2)"
+`)
+	list, err := parseTOMLConfig(file, "config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []setting{
+		{"precision", "4", "config.toml:2"},
+		{"angle", "deg", "config.toml:3"},
+		{"history_size", "500", "config.toml:4"},
+		{"theme.prompt", "bold white", "config.toml:7"},
+		{"functions.hyp(a, b)", "sqrt(a^2 + b^2)", "config.toml:10"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("got %v, expected %v", list, want)
+	}
+}
+
+func TestParseTOMLConfigErrors(t *testing.T) {
+	for _, text := range []string{"[nosuchsection]\n", "precision\n"} {
+		if _, err := parseTOMLConfig(openConfig(t, "config.toml", text), "config.toml"); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+}
+
+func TestParseJSONConfig(t *testing.T) {
+	file := openConfig(t, "config.json", `{"precision": 4, "angle": "deg", "history_size": 0.5,
+		"constants": {"g": 9.80665}, "color": true}`)
+	list, err := parseJSONConfig(file, "config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []setting{
+		{"angle", "deg", "config.json"},
+		{"color", "true", "config.json"},
+		{"constants.g", "9.80665", "config.json"},
+		{"history_size", "0.5", "config.json"},
+		{"precision", "4", "config.json"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("got %v, expected %v", list, want)
+	}
+
+	if _, err := parseJSONConfig(openConfig(t, "bad.json", "{precision"), "bad.json"); err == nil {
+		t.Errorf("invalid JSON: expected error")
+	}
+}
+
+func TestEnvSettings(t *testing.T) {
+	t.Setenv("ICALC_PRECISION", "2")
+	t.Setenv("ICALC_THEME_PROMPT", "bold")
+	t.Setenv("ICALC_HISTORY_SIZE", "50")
+	// not settings, e.g. of other tools
+	t.Setenv("ICALC_FOO", "1")
+	t.Setenv("ICALC_CONFIG_DIR", "/tmp")
+	t.Setenv("ICALC_CONFIG", "config.toml")
+
+	var names []string
+	for _, s := range envSettings() {
+		names = append(names, s.name)
+	}
+	want := []string{"history_size", "precision", "theme.prompt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, expected %v", names, want)
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	defer func() {
+		precision = -1
+		delete(constants, "g")
+		configPath = ""
+	}()
+
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.toml")
+	text := "precision = 4\n[constants]\ng = 9.80665\n"
+	if err := os.WriteFile(configPath, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// environment overrides the file
+	t.Setenv("ICALC_PRECISION", "2")
+	if err := loadSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if precision != 2 {
+		t.Errorf("precision %d, expected 2 of the environment", precision)
+	}
+	if g, ok := constants["g"]; !ok || g.Float() != 9.80665 {
+		t.Errorf("constant g = %v, expected 9.80665 of the file", g)
+	}
+
+	// command line overrides both
+	if _, _, err := parseArgs([]string{"--precision", "5", "1/3"}); err != nil {
+		t.Fatal(err)
+	}
+	if precision != 5 {
+		t.Errorf("precision %d, expected 5 of the command line", precision)
+	}
+
+	// also with options before and after a subcommand
+	for _, args := range [][]string{
+		{"--config", configPath, "--precision", "5", "eval", "1/3"},
+		{"--config", configPath, "eval", "--precision", "5", "1/3"},
+	} {
+		command, rest, err := parseArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if command != "eval" || len(rest) != 1 || rest[0] != "1/3" || precision != 5 {
+			t.Errorf("%q: command %q, arguments %q, precision %d, expected eval, [1/3], 5", args, command, rest, precision)
+		}
+	}
+
+	// unknown keys of the file are errors, unlike unknown variables
+	if err := os.WriteFile(configPath, []byte("nosuchoption = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSettings(); err == nil {
+		t.Errorf("unknown option of the file: expected error")
+	}
+}
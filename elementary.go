@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+)
+
+func init() {
+	constants["pi"] = floatValue(math.Pi)
+	constants["e"] = floatValue(math.E)
+
+	unary := map[string]struct {
+		f    func(float64) float64
+		help string
+	}{
+		"sin":  {fromAngle(math.Sin), "sine, x in radians or degrees by --angle"},
+		"cos":  {fromAngle(math.Cos), "cosine, x in radians or degrees by --angle"},
+		"tan":  {fromAngle(math.Tan), "tangent, x in radians or degrees by --angle"},
+		"asin": {toAngle(math.Asin), "arcsine in radians or degrees by --angle"},
+		"acos": {toAngle(math.Acos), "arccosine in radians or degrees by --angle"},
+		"atan": {toAngle(math.Atan), "arctangent in radians or degrees by --angle"},
+		"sinh": {math.Sinh, "hyperbolic sine"},
+		"cosh": {math.Cosh, "hyperbolic cosine"},
+		"tanh": {math.Tanh, "hyperbolic tangent"},
+		"exp":  {math.Exp, "e raised to the power of x"},
+		"ln":   {math.Log, "natural logarithm"},
+		"sqrt": {math.Sqrt, "square root"},
+	}
+	for name, fn := range unary {
+		functions[name] = function{1, 1, floatFunction(name, fn.f), name + "(x)", fn.help}
+	}
+	functions["log"] = function{1, 2, logarithm, "log(x, [base])", "logarithm, base 10 by default"}
+	functions["abs"] = function{1, 1, absolute, "abs(x)", "absolute value"}
+}
+
+// radians in the angle unit of trigonometric functions
+var angleScale = 1.0
+
+// set angle unit from option, rad or deg
+func setAngleUnit(unit string) error {
+	switch unit {
+	case "rad":
+		angleScale = 1
+	case "deg":
+		angleScale = math.Pi / 180
+	default:
+		return setUsageError("unknown angle unit " + unit + ", expected rad or deg")
+	}
+	return nil
+}
+
+// function of an angle in the angle unit
+func fromAngle(f func(float64) float64) func(float64) float64 {
+	return func(x float64) float64 { return f(x * angleScale) }
+}
+
+// function with an angle result in the angle unit
+func toAngle(f func(float64) float64) func(float64) float64 {
+	return func(x float64) float64 { return f(x) / angleScale }
+}
+
+// wrap float function, NaN result of a number is a domain error
+func floatFunction(name string, f func(float64) float64) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		x := args[0].Float()
+		res := f(x)
+		if math.IsNaN(res) && !math.IsNaN(x) {
+			return Value{}, setError(name + ": argument is out of domain")
+		}
+		return floatValue(res), nil
+	}
+}
+
+func logarithm(args []Value) (Value, error) {
+	x := args[0].Float()
+	if x <= 0 {
+		return Value{}, setError("log: argument is out of domain")
+	}
+	if len(args) == 1 {
+		return floatValue(math.Log10(x)), nil
+	}
+	base := args[1].Float()
+	if base <= 0 || base == 1 {
+		return Value{}, setError("log: base is out of domain")
+	}
+	return floatValue(math.Log(x) / math.Log(base)), nil
+}
+
+func absolute(args []Value) (Value, error) {
+	if args[0].IsInt() && args[0].i.Sign() < 0 {
+		return negateValue(args[0]), nil
+	}
+	if args[0].IsInt() {
+		return args[0], nil
+	}
+	return floatValue(math.Abs(args[0].f)), nil
+}
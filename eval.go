@@ -0,0 +1,449 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lock of calculations of concurrent requests and previews, they share
+// variables
+var calcLock sync.Mutex
+
+// time when evaluation stops with an error, zero for no limit
+var evalDeadline time.Time
+
+// max available nesting of parentheses, function calls and unary operators
+var iterationLimit = 1000
+
+// builtin function callable from expressions
+type function struct {
+	// number of accepted arguments, maxArgs < 0 means any number
+	minArgs, maxArgs int
+	call             func(args []Value) (Value, error)
+	// short description for --functions
+	usage, help string
+}
+
+var functions = map[string]function{}
+
+// bodies of functions defined by the config file by name
+var userFunctions = map[string]Node{}
+
+// function which gets its arguments unevaluated, e.g. diff(x^3, x)
+type form struct {
+	minArgs, maxArgs int
+	call             func(args []Node) (Value, error)
+	usage, help      string
+}
+
+var forms = map[string]form{}
+
+// command called with arguments, e.g. amortize(100000, 0.05/12, 360)
+type command struct {
+	minArgs, maxArgs int
+	run              func(args []Value) (string, error)
+	usage, help      string
+}
+
+var argCommands = map[string]command{}
+
+// command which takes the rest of the line, e.g. solve x^2 - 4 = 0 for x
+type lineCommand struct {
+	run         func(rest string) (string, error)
+	usage, help string
+}
+
+var lineCommands = map[string]lineCommand{}
+
+// values of variables by name
+var variables = map[string]Value{}
+
+// values of predefined constants by name
+var constants = map[string]Value{}
+
+// name of a variable, constant, function or parameter
+var identPattern = regexp.MustCompile(`^[a-zA-Z_]\w*$`)
+
+// signature of a user function, e.g. hyp(a, b)
+var signaturePattern = regexp.MustCompile(`^([a-zA-Z_]\w*)\s*\(([^()]*)\)$`)
+
+// nesting of user function calls, limited by iterationLimit
+var userCallDepth = 0
+
+// assignment of a variable, e.g. rate = 0.05/12
+var assignPattern = regexp.MustCompile(`^\s*([a-zA-Z_]\w*)\s*=([^=].*)$`)
+
+func init() {
+	argCommands["precision"] = command{1, 1, setPrecision, "precision(n)", "round results to n decimal places, -1 for full precision"}
+}
+
+// parse and calculate expression
+func evaluate(params string) (Value, error) {
+	node, err := parse(params)
+	if err != nil {
+		return Value{}, err
+	}
+	return eval(node)
+}
+
+// calculate expression, assignment stores its value to the variable
+func evaluateStatement(params string) (Value, error) {
+	m := assignPattern.FindStringSubmatch(params)
+	if m == nil {
+		return evaluate(params)
+	}
+	name := m[1]
+	if _, ok := constants[name]; ok {
+		return Value{}, setUsageError(name + " is a constant")
+	}
+	res, err := evaluate(m[2])
+	if err != nil {
+		return res, err
+	}
+	variables[name] = res
+	return res, nil
+}
+
+// define function of the config, e.g. "hyp(a, b)" = "sqrt(a^2 + b^2)"
+func defineFunction(signature, body string) error {
+	m := signaturePattern.FindStringSubmatch(strings.TrimSpace(signature))
+	if m == nil {
+		return setUsageError("invalid function " + signature + ", expected name(x, ...)")
+	}
+	name := m[1]
+	if _, ok := forms[name]; ok {
+		return setUsageError(name + " is a builtin function")
+	}
+	if _, ok := functions[name]; ok && userFunctions[name] == nil {
+		return setUsageError(name + " is a builtin function")
+	}
+	var params []string
+	if strings.TrimSpace(m[2]) != "" {
+		for _, param := range strings.Split(m[2], ",") {
+			param = strings.TrimSpace(param)
+			if !identPattern.MatchString(param) {
+				return setUsageError("invalid parameter " + param + " of " + name)
+			}
+			params = append(params, param)
+		}
+	}
+	node, err := parse(body)
+	if err != nil {
+		return setUsageError("function " + name + ": " + strings.TrimPrefix(err.Error(), "error: "))
+	}
+	usage := name + "(" + strings.Join(params, ", ") + ")"
+	functions[name] = function{len(params), len(params), userFunction(params, node), usage, "= " + node.String()}
+	userFunctions[name] = node
+	return nil
+}
+
+// call of user function evaluates its body with parameters bound to arguments
+func userFunction(params []string, body Node) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		if userCallDepth >= iterationLimit {
+			return Value{}, setError("too deep nesting of function calls")
+		}
+		userCallDepth++
+		defer func() { userCallDepth-- }()
+
+		type saved struct {
+			value  Value
+			exists bool
+		}
+		old := make([]saved, len(params))
+		for i, param := range params {
+			old[i].value, old[i].exists = variables[param]
+			variables[param] = args[i]
+		}
+		defer func() {
+			for i, param := range params {
+				if old[i].exists {
+					variables[param] = old[i].value
+				} else {
+					delete(variables, param)
+				}
+			}
+		}()
+		return eval(body)
+	}
+}
+
+// identifiers of expression which are not defined variables or constants
+func unknownIdentifiers(n Node) []string {
+	var unknown []string
+	for _, name := range identifiers(n) {
+		_, isVariable := variables[name]
+		_, isConstant := constants[name]
+		if !isVariable && !isConstant {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// calculate expression with name bound to value, previous value is restored
+func evalWith(n Node, name string, value Value) (Value, error) {
+	old, exists := variables[name]
+	variables[name] = value
+	res, err := eval(n)
+	if exists {
+		variables[name] = old
+	} else {
+		delete(variables, name)
+	}
+	return res, err
+}
+
+// calculate parsed expression
+func eval(n Node) (Value, error) {
+	if !evalDeadline.IsZero() && time.Now().After(evalDeadline) {
+		return Value{}, errTooLong()
+	}
+	switch n := n.(type) {
+	case *numberNode:
+		return n.value, nil
+	case *identNode:
+		if v, ok := variables[n.name]; ok {
+			return v, nil
+		}
+		if v, ok := constants[n.name]; ok {
+			return v, nil
+		}
+		return Value{}, setSyntaxError("unknown identifier "+n.name, n.pos)
+	case *unaryNode:
+		x, err := eval(n.x)
+		if err != nil {
+			return x, err
+		}
+		if x.symbolic {
+			return symbolicValue(&unaryNode{op: n.op, x: x.expr}), nil
+		}
+		return negateValue(x), nil
+	case *binaryNode:
+		return evalBinary(n)
+	case *callNode:
+		return evalCall(n)
+	}
+	return Value{}, setError("unsupported expression")
+}
+
+func evalBinary(n *binaryNode) (Value, error) {
+	left, err := eval(n.left)
+	if err != nil {
+		return left, err
+	}
+	right, err := eval(n.right)
+	if err != nil {
+		return right, err
+	}
+	if left.symbolic || right.symbolic {
+		return symbolicValue(&binaryNode{op: n.op, left: valueNode(left), right: valueNode(right)}), nil
+	}
+	switch n.op {
+	case "+":
+		return addValues(left, right), nil
+	case "-":
+		return subtractValues(left, right), nil
+	case "*":
+		return multiplyValues(left, right), nil
+	case "/":
+		res, err := divideValues(left, right)
+		return res, atPos(err, n.pos)
+	case "%":
+		res, err := modValues(left, right)
+		return res, atPos(err, n.pos)
+	case "^":
+		return powValues(left, right), nil
+	}
+	return Value{}, setError("unsupported operator")
+}
+
+// run command with arguments, ok is false if params is not a command
+func runCommand(params string) (res string, ok bool, err error) {
+	if fields := strings.SplitN(strings.TrimSpace(params), " ", 2); len(fields) == 2 {
+		if cmd, ok := lineCommands[fields[0]]; ok {
+			res, err = cmd.run(strings.TrimSpace(fields[1]))
+			// line commands parse parts of the line, their positions don't
+			// match the whole line
+			if e, ok := err.(*calcError); ok {
+				e.pos = -1
+			}
+			return res, true, err
+		}
+	}
+
+	node, err := parse(params)
+	if err != nil {
+		return "", false, nil
+	}
+	call, isCall := node.(*callNode)
+	if !isCall {
+		return "", false, nil
+	}
+	cmd, ok := argCommands[call.name]
+	if !ok {
+		return "", false, nil
+	}
+	if len(call.args) < cmd.minArgs || cmd.maxArgs >= 0 && len(call.args) > cmd.maxArgs {
+		return "", true, setUsageError(call.name + ": " + argsCountText(cmd.minArgs, cmd.maxArgs))
+	}
+	args, err := evalArgs(call.args)
+	if err != nil {
+		return "", true, err
+	}
+	res, err = cmd.run(args)
+	return res, true, err
+}
+
+func setPrecision(args []Value) (string, error) {
+	n, ok := args[0].Int()
+	if !ok || !n.IsInt64() || n.Int64() < -1 || n.Int64() > maxPrecision {
+		return "", setUsageError("precision must be an integer from -1 to " + strconv.Itoa(maxPrecision))
+	}
+	precision = int(n.Int64())
+	if precision < 0 {
+		return "Precision: full", nil
+	}
+	return "Precision: " + strconv.Itoa(precision) + " decimal places", nil
+}
+
+func evalArgs(nodes []Node) ([]Value, error) {
+	args := make([]Value, len(nodes))
+	for i, arg := range nodes {
+		v, err := eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+func evalCall(n *callNode) (Value, error) {
+	if f, ok := forms[n.name]; ok {
+		if len(n.args) < f.minArgs || f.maxArgs >= 0 && len(n.args) > f.maxArgs {
+			return Value{}, setSyntaxError(n.name+": "+argsCountText(f.minArgs, f.maxArgs), n.pos)
+		}
+		res, err := f.call(n.args)
+		return res, atPos(err, n.pos)
+	}
+	fn, ok := functions[n.name]
+	if !ok {
+		return Value{}, setSyntaxError("unknown function "+n.name, n.pos)
+	}
+	if len(n.args) < fn.minArgs || fn.maxArgs >= 0 && len(n.args) > fn.maxArgs {
+		return Value{}, setSyntaxError(n.name+": "+argsCountText(fn.minArgs, fn.maxArgs), n.pos)
+	}
+	args, err := evalArgs(n.args)
+	if err != nil {
+		return Value{}, err
+	}
+	for _, arg := range args {
+		if arg.symbolic {
+			call := &callNode{name: n.name, args: make([]Node, len(args))}
+			for i, arg := range args {
+				call.args[i] = valueNode(arg)
+			}
+			return symbolicValue(call), nil
+		}
+	}
+	if !evalDeadline.IsZero() && userFunctions[n.name] == nil {
+		res, err := callUntil(fn, args, evalDeadline)
+		return res, atPos(err, n.pos)
+	}
+	res, err := fn.call(args)
+	return res, atPos(err, n.pos)
+}
+
+// call of a builtin which may not stop before deadline, e.g. isprime of a
+// huge number; builtins use only their arguments, so a late call goes on in
+// the background and its result is dropped
+func callUntil(fn function, args []Value, deadline time.Time) (Value, error) {
+	type result struct {
+		value Value
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := fn.call(args)
+		done <- result{res, err}
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		return Value{}, errTooLong()
+	}
+}
+
+func errTooLong() error {
+	return setError("calculation takes too long")
+}
+
+func argsCountText(minArgs, maxArgs int) string {
+	switch {
+	case maxArgs < 0:
+		return "expected at least " + strconv.Itoa(minArgs) + " arguments"
+	case minArgs == maxArgs && minArgs == 1:
+		return "expected 1 argument"
+	case minArgs == maxArgs:
+		return "expected " + strconv.Itoa(minArgs) + " arguments"
+	}
+	return "expected " + strconv.Itoa(minArgs) + " to " + strconv.Itoa(maxArgs) + " arguments"
+}
+
+// list of supported functions for help
+func functionsList() string {
+	usages := map[string][2]string{}
+	for name, fn := range functions {
+		usages[name] = [2]string{fn.usage, fn.help}
+	}
+	for name, f := range forms {
+		usages[name] = [2]string{f.usage, f.help}
+	}
+	return usageList(usages)
+}
+
+// list of commands with arguments for help
+func argCommandsList() string {
+	usages := map[string][2]string{}
+	for name, cmd := range argCommands {
+		usages[name] = [2]string{cmd.usage, cmd.help}
+	}
+	for name, cmd := range lineCommands {
+		usages[name] = [2]string{cmd.usage, cmd.help}
+	}
+	return usageList(usages)
+}
+
+// help lines sorted by name from usage and help pairs
+func usageList(usages map[string][2]string) string {
+	names := make([]string, 0, len(usages))
+	for name := range usages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := ""
+	for _, name := range names {
+		res += helpLine(usages[name][0], usages[name][1])
+	}
+	return res
+}
+
+func helpLine(usage, help string) string {
+	tabs := "\t"
+	switch {
+	case len(usage) < 8:
+		tabs = "\t\t\t"
+	case len(usage) < 16:
+		tabs = "\t\t"
+	}
+	return "\t" + usage + tabs + help + "\n"
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// exit codes are part of the interface for scripts, their numbers must not
+// change
+var exitCodeTests = []struct {
+	err  error
+	code int
+}{
+	{&calcError{mathError, -1, "you tried to divide by zero"}, 1},
+	{&calcError{usageError, -1, "command not found"}, 2},
+	{&calcError{assertionError, -1, "assertion failed"}, 3},
+	{&calcError{syntaxError, 0, "unexpected symbol"}, 4},
+	// errors from outside of calculations, e.g. of reading files
+	{errors.New("open x.icalc: no such file or directory"), 2},
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range exitCodeTests {
+		if code := exitCode(test.err); code != test.code {
+			t.Errorf("exitCode(%v) = %d, expected %d", test.err, code, test.code)
+		}
+	}
+}
+
+var processTests = []struct {
+	args []string
+	out  string
+	code int
+}{
+	{[]string{"1 + 1"}, "2\n", exitOK},
+	{[]string{"1 / 0"}, "", exitMath},
+	{[]string{"2 +"}, "", exitSyntax},
+	{[]string{"nosuchfunction(1)"}, "", exitSyntax},
+	{[]string{"--nosuchoption"}, "", exitUsage},
+	{[]string{"assert 1 == 2"}, "", exitAssert},
+	// missing expression
+	{[]string{}, "", exitSyntax},
+}
+
+func TestProcess(t *testing.T) {
+	for _, test := range processTests {
+		resetVariables()
+		var code int
+		out, errText := captureOutput(t, func() {
+			code = process(test.args)
+		})
+		if out != test.out || code != test.code {
+			t.Errorf("process %q = %q, code %d, expected %q, code %d", test.args, out, code, test.out, test.code)
+		}
+		// errors go to stderr only
+		if code != exitOK && errText == "" {
+			t.Errorf("process %q: no error on stderr", test.args)
+		}
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	quiet = true
+	defer func() { quiet = false }()
+
+	out, errText := captureOutput(t, func() {
+		process([]string{"2^10"})
+	})
+	if out != "1024\n" || errText != "" {
+		t.Errorf("quiet result: stdout %q, stderr %q", out, errText)
+	}
+
+	var code int
+	out, errText = captureOutput(t, func() {
+		code = process([]string{"1 / 0"})
+	})
+	if out != "" || errText != "" || code != exitMath {
+		t.Errorf("quiet error: stdout %q, stderr %q, code %d, expected only code %d", out, errText, code, exitMath)
+	}
+}
+
+var printAllTests = []struct {
+	args []string
+	all  bool
+	out  string
+}{
+	{[]string{"x = 5; x * 2"}, false, "10\n"},
+	{[]string{"x = 5; x * 2"}, true, "5\n10\n"},
+	{[]string{"x = 5"}, false, ""},
+	{[]string{"x = 5"}, true, "5\n"},
+	{[]string{"1 + 1", "2 * 3"}, false, "6\n"},
+	{[]string{"1 + 1", "2 * 3"}, true, "2\n6\n"},
+}
+
+func TestPrintAll(t *testing.T) {
+	defer func() { printAll = false }()
+	for _, test := range printAllTests {
+		resetVariables()
+		printAll = test.all
+		out, _ := captureOutput(t, func() {
+			process(test.args)
+		})
+		if out != test.out {
+			t.Errorf("process %q with --all %v = %q, expected %q", test.args, test.all, out, test.out)
+		}
+	}
+}
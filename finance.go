@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// decimal places of money amounts when precision is not set
+const moneyPrecision = 2
+
+// max decimal places of amortization schedule amounts
+const maxMoneyPrecision = 6
+
+// max iterations of rate and irr search
+const financeIterations = 100
+
+func init() {
+	functions["pv"] = function{3, 5, presentValue, "pv(rate, nper, pmt, [fv], [type])", "present value of an investment"}
+	functions["fv"] = function{3, 5, futureValue, "fv(rate, nper, pmt, [pv], [type])", "future value of an investment"}
+	functions["pmt"] = function{3, 5, payment, "pmt(rate, nper, pv, [fv], [type])", "payment per period of a loan"}
+	functions["nper"] = function{3, 5, periods, "nper(rate, pmt, pv, [fv], [type])", "number of periods of an investment"}
+	functions["rate"] = function{3, 6, interestRate, "rate(nper, pmt, pv, [fv], [type], [guess])", "interest rate per period"}
+	functions["npv"] = function{2, -1, netPresentValue, "npv(rate, value1, ...)", "net present value of cash flows"}
+	functions["irr"] = function{2, -1, internalRate, "irr(value0, value1, ...)", "internal rate of return of cash flows"}
+
+	argCommands["amortize"] = command{3, 3, amortize, "amortize(principal, rate, periods)", "amortization schedule of a loan"}
+}
+
+// convert arguments to floats, missing optional arguments are zero
+func floatArgs(args []Value, count int) []float64 {
+	result := make([]float64, count)
+	for index, arg := range args {
+		result[index] = arg.Float()
+	}
+	return result
+}
+
+// round money amount to the current precision
+func money(amount float64) Value {
+	if precision < 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return floatValue(amount)
+	}
+	scale := math.Pow(10, float64(precision))
+	return floatValue(math.Round(amount*scale) / scale)
+}
+
+// payments are made at the beginning of a period if type is 1
+func paymentType(t float64) (float64, error) {
+	if t != 0 && t != 1 {
+		return 0, setError("type must be 0 or 1")
+	}
+	return t, nil
+}
+
+// (1+rate)^nper and factor of payments for a future value
+func growth(rate, nper, t float64) (float64, float64) {
+	g := math.Pow(1+rate, nper)
+	if rate == 0 {
+		return g, nper
+	}
+	return g, (1 + rate*t) * (g - 1) / rate
+}
+
+func presentValue(args []Value) (Value, error) {
+	a := floatArgs(args, 5)
+	rate, nper, pmt, fv := a[0], a[1], a[2], a[3]
+	t, err := paymentType(a[4])
+	if err != nil {
+		return Value{}, err
+	}
+	g, k := growth(rate, nper, t)
+	return money(-(fv + pmt*k) / g), nil
+}
+
+func futureValue(args []Value) (Value, error) {
+	a := floatArgs(args, 5)
+	rate, nper, pmt, pv := a[0], a[1], a[2], a[3]
+	t, err := paymentType(a[4])
+	if err != nil {
+		return Value{}, err
+	}
+	g, k := growth(rate, nper, t)
+	return money(-(pv*g + pmt*k)), nil
+}
+
+func payment(args []Value) (Value, error) {
+	a := floatArgs(args, 5)
+	rate, nper, pv, fv := a[0], a[1], a[2], a[3]
+	t, err := paymentType(a[4])
+	if err != nil {
+		return Value{}, err
+	}
+	if nper == 0 {
+		return Value{}, setError("pmt: number of periods can't be zero")
+	}
+	g, k := growth(rate, nper, t)
+	return money(-(fv + pv*g) / k), nil
+}
+
+func periods(args []Value) (Value, error) {
+	a := floatArgs(args, 5)
+	rate, pmt, pv, fv := a[0], a[1], a[2], a[3]
+	t, err := paymentType(a[4])
+	if err != nil {
+		return Value{}, err
+	}
+	if rate == 0 {
+		if pmt == 0 {
+			return Value{}, setError("nper: payment can't be zero")
+		}
+		return floatValue(-(pv + fv) / pmt), nil
+	}
+	k := pmt * (1 + rate*t)
+	n := math.Log((k-fv*rate)/(k+pv*rate)) / math.Log(1+rate)
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return Value{}, setError("nper: no solution for these arguments")
+	}
+	return floatValue(n), nil
+}
+
+func interestRate(args []Value) (Value, error) {
+	a := floatArgs(args, 6)
+	nper, pmt, pv, fv := a[0], a[1], a[2], a[3]
+	t, err := paymentType(a[4])
+	if err != nil {
+		return Value{}, err
+	}
+	guess := 0.1
+	if len(args) == 6 {
+		guess = a[5]
+	}
+	// balance at the end of all periods must be zero
+	f := func(rate float64) float64 {
+		g, k := growth(rate, nper, t)
+		return pv*g + pmt*k + fv
+	}
+	rate, ok := newton(f, guess)
+	if !ok {
+		return Value{}, setError("rate: no solution found, try another guess")
+	}
+	return floatValue(rate), nil
+}
+
+func netPresentValue(args []Value) (Value, error) {
+	a := floatArgs(args, len(args))
+	rate := a[0]
+	if rate == -1 {
+		return Value{}, setError("npv: rate can't be -1")
+	}
+	return money(npv(rate, a[1:], 1)), nil
+}
+
+// sum of cash flows discounted from the first period
+func npv(rate float64, values []float64, first int) float64 {
+	result := 0.0
+	for index, value := range values {
+		result += value / math.Pow(1+rate, float64(index+first))
+	}
+	return result
+}
+
+func internalRate(args []Value) (Value, error) {
+	values := floatArgs(args, len(args))
+	positive, negative := false, false
+	for _, value := range values {
+		positive = positive || value > 0
+		negative = negative || value < 0
+	}
+	if !positive || !negative {
+		return Value{}, setError("irr: cash flows must contain positive and negative values")
+	}
+	rate, ok := newton(func(rate float64) float64 {
+		return npv(rate, values, 0)
+	}, 0.1)
+	if !ok {
+		return Value{}, setError("irr: no solution found")
+	}
+	return floatValue(rate), nil
+}
+
+// find root of f near guess with Newton's method and numeric derivative
+func newton(f func(float64) float64, guess float64) (float64, bool) {
+	x := guess
+	for i := 0; i < financeIterations; i++ {
+		y := f(x)
+		if math.Abs(y) < 1e-10 {
+			return x, true
+		}
+		h := 1e-7 * math.Max(1, math.Abs(x))
+		d := (f(x+h) - f(x-h)) / (2 * h)
+		if d == 0 || math.IsNaN(d) {
+			return x, false
+		}
+		next := x - y/d
+		if next <= -1 {
+			// rate must stay above -100%
+			next = (x - 1) / 2
+		}
+		if math.Abs(next-x) < 1e-12 {
+			return next, true
+		}
+		x = next
+	}
+	return x, false
+}
+
+// print amortization schedule with payments rounded to the current precision,
+// the last payment absorbs rounding so that the balance ends at exactly zero
+func amortize(args []Value) (string, error) {
+	a := floatArgs(args, 3)
+	principal, rate := a[0], a[1]
+	n, ok := args[2].Int()
+	if !ok || n.Sign() <= 0 || !n.IsInt64() || n.Int64() > 10000 {
+		return "", setError("amortize: periods must be an integer from 1 to 10000")
+	}
+	nper := n.Int64()
+	if principal <= 0 {
+		return "", setError("amortize: principal must be positive")
+	}
+	if rate <= -1 {
+		return "", setError("amortize: rate must be greater than -1")
+	}
+
+	digits := precision
+	if digits < 0 {
+		digits = moneyPrecision
+	} else if digits > maxMoneyPrecision {
+		digits = maxMoneyPrecision
+	}
+	scale := math.Pow(10, float64(digits))
+	g, k := growth(rate, float64(nper), 0)
+	// payments and their total must fit into int64 minor units
+	largest := math.Max(principal, math.Abs(principal*g/k)*float64(nper)) * scale
+	if !(largest < math.MaxInt64) {
+		return "", setError("amortize: amounts are too big")
+	}
+	// amounts are counted in minor units, e.g. cents
+	minor := func(amount float64) int64 {
+		return int64(math.Round(amount * scale))
+	}
+	format := func(amount int64) string {
+		return fmt.Sprintf("%.*f", digits, float64(amount)/scale)
+	}
+
+	balance := minor(principal)
+	pmt := minor(principal * g / k)
+
+	rows := [][]string{{"Period", "Payment", "Interest", "Principal", "Balance"}}
+	var totalPayment, totalInterest int64
+	for period := int64(1); period <= nper; period++ {
+		interest := minor(float64(balance) / scale * rate)
+		paid := pmt - interest
+		if period == nper || paid > balance {
+			paid = balance
+		}
+		balance -= paid
+		totalPayment += paid + interest
+		totalInterest += interest
+		rows = append(rows, []string{fmt.Sprint(period), format(paid + interest), format(interest), format(paid), format(balance)})
+		if balance == 0 {
+			break
+		}
+	}
+	rows = append(rows, []string{"Total", format(totalPayment), format(totalInterest), format(totalPayment - totalInterest), ""})
+
+	return formatTable(rows), nil
+}
+
+// align table columns to the right
+func formatTable(rows [][]string) string {
+	var widths []int
+	for _, row := range rows {
+		for index, cell := range row {
+			if index >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if len(cell) > widths[index] {
+				widths[index] = len(cell)
+			}
+		}
+	}
+	lines := make([]string, len(rows))
+	for index, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%*s", widths[i], cell)
+		}
+		lines[index] = strings.TrimRight(strings.Join(cells, "  "), " ")
+	}
+	return strings.Join(lines, "\n")
+}
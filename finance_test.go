@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// expected values are results of the same formulas in a spreadsheet
+var financeTests = []struct {
+	in   string
+	want float64
+	// allowed difference, spreadsheet values are rounded
+	tolerance float64
+}{
+	{"pmt(0.05/12, 360, 100000)", -536.82, 0.005},
+	{"pmt(0.05/12, 360, 100000, 0, 1)", -534.59, 0.005},
+	{"pmt(0, 10, 1000)", -100, 0},
+	{"pv(0.08/12, 240, 500)", -59777.15, 0.005},
+	{"fv(0.06/12, 120, -200)", 32775.87, 0.005},
+	{"fv(0.05, 10, -100, -1000, 1)", 2949.57, 0.005},
+	{"nper(0.01, -100, 1000)", 10.5886, 0.00005},
+	{"nper(0, -100, 1000)", 10, 0},
+	{"rate(360, -536.82, 100000)", 0.0041666, 0.0000001},
+	{"npv(0.1, -10000, 3000, 4200, 6800)", 1188.44, 0.005},
+	{"irr(-70000, 12000, 15000, 18000, 21000, 26000)", 0.086631, 0.0000005},
+}
+
+func TestFinance(t *testing.T) {
+	resetVariables()
+	for _, test := range financeTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.in, err)
+			continue
+		}
+		if math.Abs(res.Float()-test.want) > test.tolerance {
+			t.Errorf("%s = %v, expected %v", test.in, res, test.want)
+		}
+	}
+}
+
+var financeErrorTests = []string{
+	"pmt(0.1, 0, 1000)",
+	"pv(0.1, 10, 100, 0, 2)",
+	"nper(0, 0, 1000)",
+	"npv(-1, 100)",
+	"irr(100, 200)",
+}
+
+func TestFinanceErrors(t *testing.T) {
+	resetVariables()
+	for _, in := range financeErrorTests {
+		if _, err := evaluate(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestAmortize(t *testing.T) {
+	out, err := amortize([]Value{int64Value(1000), floatValue(0.01), int64Value(2)})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := "Period  Payment  Interest  Principal  Balance\n" +
+		"     1   507.51     10.00     497.51   502.49\n" +
+		"     2   507.51      5.02     502.49     0.00\n" +
+		" Total  1015.02     15.02    1000.00"
+	if out != want {
+		t.Errorf("got\n%s\nexpected\n%s", out, want)
+	}
+
+	// amounts beyond int64 cents
+	for _, principal := range []float64{1e17, 1e20, math.Inf(1)} {
+		_, err := amortize([]Value{floatValue(principal), floatValue(0.01), int64Value(2)})
+		if err == nil || !strings.Contains(err.Error(), "too big") {
+			t.Errorf("amortize(%g, 0.01, 2): got %v, expected error", principal, err)
+		}
+	}
+	for _, rate := range []float64{-1, -2} {
+		if _, err := amortize([]Value{int64Value(1000), floatValue(rate), int64Value(2)}); err == nil {
+			t.Errorf("amortize(1000, %g, 2): expected error", rate)
+		}
+	}
+	if _, err := amortize([]Value{floatValue(1e15), floatValue(100), int64Value(10)}); err == nil {
+		t.Errorf("amortize(1e15, 100, 10): expected error for too big payments")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"./terminal"
+)
+
+// file of history of interactive mode, empty to keep history in memory only
+var historyPath = defaultHistoryPath()
+
+// history in the user state directory, e.g. ~/.local/state/icalc/history
+func defaultHistoryPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "icalc", "history")
+}
+
+func setHistoryPath(path string) error {
+	historyPath = path
+	return nil
+}
+
+// load history of previous sessions, a missing file is an empty history
+func loadHistory(term *terminal.Terminal) error {
+	if historyPath == "" {
+		return nil
+	}
+	file, err := os.Open(historyPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH); err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	lines, results, err := readHistory(file)
+	if err != nil {
+		return err
+	}
+	term.SetHistory(lines, results)
+	return nil
+}
+
+// add lines of this session to the file, lines saved meanwhile by other
+// sessions are kept, the oldest ones over historySize are dropped
+func saveHistory(term *terminal.Terminal) error {
+	newLines, newResults := term.NewHistory()
+	if historyPath == "" || len(newLines) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(historyPath), 0700); err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	file, err := os.OpenFile(historyPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	defer file.Close()
+
+	// the lock is released by Close
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	lines, results, err := readHistory(file)
+	if err != nil {
+		return err
+	}
+	lines, results = append(lines, newLines...), append(results, newResults...)
+	if n := len(lines) - historySize; n > 0 {
+		lines, results = lines[n:], results[n:]
+	}
+
+	var text strings.Builder
+	for i, line := range lines {
+		text.WriteString(line + "\t" + results[i] + "\n")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	if err := file.Truncate(0); err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	if _, err := file.WriteString(text.String()); err != nil {
+		return setUsageError("history: " + err.Error())
+	}
+	return nil
+}
+
+// read lines of history with results after the last tab
+func readHistory(r io.Reader) ([]string, []string, error) {
+	var lines, results []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		line, result := scanner.Text(), ""
+		if i := strings.LastIndex(line, "\t"); i >= 0 {
+			line, result = line[:i], line[i+1:]
+		}
+		if line != "" {
+			lines, results = append(lines, line), append(results, result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, setUsageError("history: " + err.Error())
+	}
+	return lines, results, nil
+}
@@ -9,13 +9,14 @@ package main
 
 import (
 	"./terminal"
-	"errors"
 	"fmt"
 	"math"
 	"os"
+	"os/signal"
 	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const (
@@ -29,337 +30,85 @@ const (
 	WHITE
 )
 
-type Splited struct {
-	operator string
-	nums []string
-	parsedNums []float64
-}
-
-// The sequence of operators matters!
-var operators = [7]string{"+", "-", "%", "*", "/", ":", "^"}
-var operatorsPattern = `+\-%*/:^`
-
-var isParentheses bool
-// max available iterations in recursive calls
-var iteration = 1000
-
 const termText = " icalc> "
 
 const headInfo = `Inline calculator
 (c) 2020 Pavlo Zubkov
 This is free software with ABSOLUTELY NO WARRANTY.
 Usage:
-  icalc <operand1><operator><operand2>[<operator><operandN>...] | <command>
+  icalc [options] [eval] <statement>[; <statement>...] [<statement>...]
+  icalc [options] repl | serve
 `
 
-var helpInfo = headInfo + `
-For interactive mode run icalc without arguments.
-
-Commands:
-	-h, --help		for more information about a commands
-	-o, --operators		list of supported operators
+var interactiveInfo = `
+Interactive commands:
 	h, history		history of calculations in interactive mode
 	c, cls, clear		clear terminal in interactive mode
 	q, quit, exit		exit interactive mode
 `
 
-var operatorsInfo = headInfo + `
-Supported operators:
-	+	addition
-	-	subtraction
-	*	multiplication
-	/, :	division
-	^	exponentiation
-	%	modulo
-`
-
-// convert args to float64
-func parseOperands(c []string) ([]float64, error) {
-	var result []float64
-	for _, num := range c {
-		parsedNum, err := strconv.ParseFloat(num, 64)
-		if err != nil {
-			err = parseError(err)
-			return result, err
-		}
-		result = append(result, parsedNum)
-	}
-	return result, nil
+// commands of interactive mode, other commands start with -
+var interactiveCommands = map[string]bool{
+	"h": true, "history": true,
+	"c": true, "cls": true, "clear": true,
+	"q": true, "quit": true, "exit": true,
 }
 
-// split expression by operator
-func splitParams(param string) (Splited, error) {
-	var returned Splited
-	for _, operator := range operators {
-		param = strings.Replace(param, "." + operator, operator, -1)
-		pattern := `\d+\` + operator
-		match, err := regexp.MatchString(pattern, param)
-		if err != nil {
-			return returned, err
-		}
-		if match {
-			nums := strings.Split(param, operator)
-			if nums[len(nums)-1] == "" {
-				nums = nums[:len(nums)-1]
-			}
-			if operator == "-" {
-				for index, sp := range nums {
-					if sp == "" {
-						nums[index + 1] = "-" + nums[index + 1]
-						nums = remove(nums, index)
-					}
-				}
-			}
-			splited := Splited{
-				operator: operator,
-				nums:    nums,
-			}
-			return splited, nil
-		}
-	}
-
-	return returned, setError("no operator found")
+// command of interactive mode starting with :, e.g. :theme light
+type replCommand struct {
+	run         func(args string, term *terminal.Terminal) (string, error)
+	usage, help string
 }
 
-// parsing and calculation expressions in parentheses
-func parseParentheses(params string) (string, error) {
-	var err error
-
-	// check iteration limit
-	if iteration <= 0 {
-		iteration = 1000
-		return "", setError("iteration limit is reached")
-	}
-	iteration--
-
-	// TODO: optimize
-	outerParentheses := regexp.MustCompile(`^\(([\d.` + operatorsPattern + `]+|(?:[\d.` + operatorsPattern + `]*\([\d.` + operatorsPattern + `()]*\)[\d.` + operatorsPattern + `]*)+)\)$`)
-
-	// remove parent parentheses (5+5) if exists
-	oRes := outerParentheses.FindStringSubmatch(params)
-	if len(oRes) == 2 {
-		params = oRes[1]
-
-		// check if another parent parentheses present
-		oRes = outerParentheses.FindStringSubmatch(params)
-		if len(oRes) == 2 {
-			return parseParentheses(params)
-		}
-	}
-
-	// calculate parentheses expression and replace it by result
-	innerParentheses := regexp.MustCompile(`\(([\d.` + operatorsPattern + `]+)\)`)
-	params = innerParentheses.ReplaceAllStringFunc(params, func(s string) string {
-		var br float64
-		var numberOnly bool
-		isParentheses = false
-
-		// remove parentheses from expression
-		sRes := innerParentheses.FindStringSubmatch(s)
-		if len(sRes) == 2 {
-			s = sRes[1]
-		}
-
-		// if only number in parentheses
-		numberOnly, err = regexp.MatchString(`^([\d.]+)$`, s)
-		if err != nil {
-			return ""
-		}
-		if numberOnly {
-			return s
-		}
-
-		// parse and calculate expression
-		br, err = parseParams(s)
-		if err != nil {
-			return ""
-		}
-
-		return fmt.Sprint(br)
-	})
+var replCommands = map[string]replCommand{}
 
-	// check if parentheses present
-	isParentheses, err = regexp.MatchString(`\(.*\)`, params)
-	if err != nil {
-		return params, err
-	}
-	if isParentheses {
-		return parseParentheses(params)
+// list of : commands, built from replCommands table
+func replCommandsList() string {
+	usages := map[string][2]string{}
+	for name, cmd := range replCommands {
+		usages[name] = [2]string{cmd.usage, cmd.help}
 	}
-
-	return params, err
+	return usageList(usages)
 }
 
-func parseParams(params string) (float64, error) {
-	var result float64
-	var err error
-	var splited Splited
-
-	// check iteration limit
-	if iteration <= 0 {
-		iteration = 1000
-		return result, setError("iteration limit is reached")
+// run :name args, the colon is stripped
+func runReplCommand(params string, term *terminal.Terminal) (string, error) {
+	fields := strings.SplitN(params, " ", 2)
+	cmd, ok := replCommands[fields[0]]
+	if !ok {
+		return "", setUsageError("unknown command :" + fields[0])
 	}
-	iteration--
-
-	if isParentheses {
-		params, err = parseParentheses(params)
-		if err != nil {
-			return result, err
-		}
+	args := ""
+	if len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
 	}
-
-	splited, err = splitParams(params)
-	if err != nil {
-		return result, err
-	}
-
-	if len(splited.nums) < 2 {
-		return result, setError("not enough arguments")
-	}
-
-	splited.parsedNums = make([]float64, len(splited.nums))
-
-	for index, val := range splited.nums {
-		// checking for expressions in operands
-		match, err := regexp.MatchString(`\d+(\.*)[` + operatorsPattern + `]+(\.*)\d+`, val)
-
-		if err != nil {
-			return result, err
-		}
-		if match {
-			matchedCalc, err := parseParams(val)
-			if err != nil {
-				return result, err
-			}
-			// set result of expression for next calculation
-			splited.parsedNums[index] = matchedCalc
-		} else {
-			parsedNum, err := strconv.ParseFloat(val, 64)
-			if err != nil {
-				err = parseError(err)
-				return result, err
-			}
-			splited.parsedNums[index] = parsedNum
-		}
-	}
-	result, err = calculate(splited)
-	if err != nil {
-		return result, err
-	}
-
-	return result, err
+	return cmd.run(args, term)
 }
 
-func calculate(splited Splited) (float64, error) {
-	var result float64
-	var nums []float64
-	var err error
-
-	if len(splited.parsedNums) > 1 {
-		nums = splited.parsedNums
-	} else {
-		nums, err = parseOperands(splited.nums)
-	}
-
-	if err == nil {
-		switch splited.operator {
-			case "*":
-				result = multiply(nums)
-			case "/":
-				result, err = divide(nums)
-			case "^":
-				result = pow(nums)
-			case "%":
-				result, err = modd(nums)
-			case "+":
-				result = add(nums)
-			case "-":
-				result = subtract(nums)
-			default:
-				err = setError("unsupported operator")
-		}
-	}
-
-	return result, err
-}
-
-// math functions start
-func multiply(nums []float64) float64 {
-	result := 1.0
-	for _, num := range nums {
-		result *= num
-	}
-	return result
-}
-
-func divide(nums []float64) (float64, error) {
-	var result float64
-	for index, num := range nums {
-		if index > 0 && num == 0.0 {
-			return 0.0, setError("you tried to divide by zero")
-		}
-		if result == 0 {
-			result = num
-		} else {
-			result /= num
-		}
-	}
-	return result, nil
+// list of commands with arguments, built from commands table
+func argCommandsInfo() string {
+	return "\nCommands with arguments:\n" + argCommandsList()
 }
 
-func pow(nums []float64) float64 {
-	var result float64
-	for index, num := range nums {
-		if index == 0 && result == 0 {
-			result = num
-		} else {
-			result = math.Pow(result, num)
-		}
-	}
-	return result
-}
-
-func modd(nums []float64) (float64, error) {
-	var result float64
-	for index, num := range nums {
-		if index > 0 && num == 0.0 {
-			return 0.0, setError("Modulo by zero")
-		}
-		if index == 0 && result == 0 {
-			result = num
-		} else {
-			result = math.Mod(result, num)
-		}
-	}
-	return result, nil
-}
-
-func add(nums []float64) float64 {
-	result := 0.0
-	for _, num := range nums {
-		result += num
-	}
-	return result
-}
+var operatorsInfo = headInfo + `
+Supported operators:
+	+	addition
+	-, −	subtraction
+	*, ×	multiplication
+	/, :, ÷	division
+	^	exponentiation
+	², ³	square, cube
+	√	square root, e.g. √2
+	%	modulo
 
-func subtract(nums []float64) float64 {
-	var result float64
-	for index, num := range nums {
-		if index == 0 && result == 0 {
-			result = num
-		} else {
-			result -= num
-		}
-	}
-	return result
-}
-// math functions end
+^ binds tighter than unary minus and is calculated from left to
+right, e.g. -2^2 = -4 and 2^3^2 = 64; earlier versions gave 4 for
+-2^2, write (-2)^2 for the square of a negative number
+`
 
-// remove element from slice
-func remove(slice []string, i int) []string {
-	copy(slice[i:], slice[i+1:])
-	return slice[:len(slice)-1]
+// list of supported functions, built from functions table
+func functionsInfo() string {
+	return headInfo + "\nSupported functions:\n" + functionsList()
 }
 
 // check input commands in bash mode
@@ -367,9 +116,11 @@ func checkCommands(command string) string {
 	res := ""
 	switch command {
 	case "-h", "--help":
-		res = helpInfo
+		res = helpText()
 	case "-o", "--operators":
 		res = operatorsInfo
+	case "--functions":
+		res = functionsInfo()
 	default:
 		res = "Command not found"
 	}
@@ -396,9 +147,11 @@ func checkInteractiveCommands(command string, term *terminal.Terminal) string {
 			res = "\nNo history found"
 		}
 	case "-h", "--help":
-		res = "\n" + helpInfo
+		res = "\n" + helpText()
 	case "-o", "--operators":
 		res = "\n" + operatorsInfo
+	case "--functions":
+		res = "\n" + functionsInfo()
 	default:
 		res = "\nCommand not found"
 	}
@@ -408,71 +161,174 @@ func checkInteractiveCommands(command string, term *terminal.Terminal) string {
 // exit interactive mode
 func exitCommand(term *terminal.Terminal) {
 	_, _ = term.Write([]byte("Exit\r\n"))
+	err := saveHistory(term)
 	term.ReleaseFromStdInOut()
+	if err != nil {
+		fmt.Println(outColors.paint(currentTheme.error, err.Error()))
+	}
 	fmt.Println("")
 	os.Exit(0)
 }
 
 // check if input is command
 func checkIsCommand(params string) (bool, error) {
-	return regexp.MatchString(`^([-a-zA-Z]+)+([^\d])*$`, params)
+	// constants and variables are expressions
+	if _, ok := constants[params]; ok {
+		return false, nil
+	}
+	if _, ok := variables[params]; ok {
+		return false, nil
+	}
+	if interactiveCommands[params] {
+		return true, nil
+	}
+	// negated constant or variable, e.g. -pi
+	name := strings.TrimPrefix(params, "-")
+	if _, ok := constants[name]; ok {
+		return false, nil
+	}
+	if _, ok := variables[name]; ok {
+		return false, nil
+	}
+	return regexp.MatchString(`^--?[a-zA-Z]+$`, params)
 }
 
-// bash mode
-func process(params string) {
-	res := 0.0
-	var err error
-	var isCommand bool
-	command := ""
-
+// calculate input of bash and batch modes
+func calculate(params string) (output, error) {
 	// check if command
-	isCommand, err = checkIsCommand(params)
+	isCommand, err := checkIsCommand(params)
+	if err != nil {
+		return output{}, err
+	}
+	if isCommand {
+		command := checkCommands(params)
+		if command == "Command not found" {
+			return output{}, setUsageError("command not found: " + params)
+		}
+		return output{text: command}, nil
+	}
 
-	if err == nil {
-		if isCommand {
-			command = checkCommands(params)
-			if command != "" {
-				fmt.Println(command)
-			}
-		} else {
-			var operandOnly bool
-			operandOnly, err = checkInput(params)
-			if err == nil {
-				params, err = cleanParams(params)
-				if err == nil {
-					if operandOnly {
-						// if number only
-						res, err = strconv.ParseFloat(params, 64)
-						if err != nil {
-							err = parseError(err)
-						}
-					} else {
-						res, err = parseParams(params)
-					}
-					if err == nil {
-						fmt.Println(res)
-					}
-				}
+	command, isArgCommand, err := runCommand(params)
+	if isArgCommand {
+		return output{text: command}, err
+	}
+	res, err := evaluateStatement(params)
+	if err != nil {
+		return output{}, err
+	}
+	return output{res.String(), &res, assignPattern.MatchString(params)}, nil
+}
+
+// bash mode, evaluate statements of arguments in order and print the last
+// result or every one with --all, stops at the first error, returns exit code
+func process(args []string) int {
+	var statements []string
+	for _, arg := range args {
+		statements = append(statements, splitStatements(arg)...)
+	}
+	if len(statements) == 0 {
+		// report missing expression
+		statements = []string{""}
+	}
+
+	for i, statement := range statements {
+		out, err := calculate(statement)
+		if err != nil {
+			printResult(statement, "", out, err)
+			return exitCode(err)
+		}
+		if printAll || i == len(statements)-1 {
+			printResult(statement, "", out, nil)
+		}
+	}
+	return exitOK
+}
+
+// split statements separated by ';' outside of quotes, empty ones are dropped
+func splitStatements(line string) []string {
+	var statements []string
+	quoted, start := false, 0
+	for i := 0; i <= len(line); i++ {
+		if i < len(line) && line[i] == '"' {
+			quoted = !quoted
+		}
+		if i == len(line) || line[i] == ';' && !quoted {
+			if statement := strings.TrimSpace(line[start:i]); statement != "" {
+				statements = append(statements, statement)
 			}
+			start = i + 1
 		}
 	}
+	return statements
+}
+
+// time of a preview, the preview runs on every key press and holds the
+// terminal, results of slower calls are shown only once the line is entered
+const previewTimeout = 100 * time.Millisecond
+
+// result of the line shown while it's typed, empty for commands, slow calls
+// and incomplete or invalid expressions, their errors are shown once entered;
+// assignments are previewed without storing the value
+func previewResult(line string) string {
+	text := strings.TrimSpace(line)
+	if text == "" || strings.HasPrefix(text, ":") {
+		return ""
+	}
+	// a preview over its time stops at its next step and releases the lock
+	if !calcLock.TryLock() {
+		return ""
+	}
+	done := make(chan string, 1)
+	go func() {
+		defer calcLock.Unlock()
+		evalDeadline = time.Now().Add(previewTimeout)
+		defer func() { evalDeadline = time.Time{} }()
+		done <- preview(text)
+	}()
+	select {
+	case res := <-done:
+		return res
+	case <-time.After(previewTimeout):
+		return ""
+	}
+}
 
+// result of text for previewResult, calcLock is held
+func preview(text string) string {
+	if isCommand, err := checkIsCommand(text); err != nil || isCommand {
+		return ""
+	}
+	if _, ok := lineCommands[strings.SplitN(text, " ", 2)[0]]; ok {
+		return ""
+	}
+	if m := assignPattern.FindStringSubmatch(text); m != nil {
+		text = strings.TrimSpace(m[2])
+	}
+	node, err := parse(text)
 	if err != nil {
-		fmt.Println(setFgColor(RED, setBoldError(err)))
+		return ""
 	}
+	res, err := eval(node)
+	if err != nil || res.String() == text {
+		return ""
+	}
+	return "= " + res.String()
 }
 
 func interactiveProcess(params string, term *terminal.Terminal) {
-	res := 0.0
+	var res Value
 	var err error
 	var isCommand bool
 	command := ""
+	result := ""
 
-	// check if command
-	isCommand, err = checkIsCommand(params)
-
-	if err == nil {
-	 	if isCommand {
+	if strings.HasPrefix(params, ":") {
+		command, err = runReplCommand(strings.TrimPrefix(params, ":"), term)
+		if err == nil {
+			fmt.Println(command)
+		}
+	} else if isCommand, err = checkIsCommand(params); err == nil {
+		if isCommand {
 			command = checkInteractiveCommands(params, term)
 			if command != "" {
 				if command != "-clear-" {
@@ -480,22 +336,20 @@ func interactiveProcess(params string, term *terminal.Terminal) {
 				}
 			}
 		} else {
-			var operandOnly bool
-			operandOnly, err = checkInput(params)
-			if err == nil {
-				params, err = cleanParams(params)
+			var isArgCommand bool
+			command, isArgCommand, err = runCommand(params)
+			if isArgCommand {
+				if err == nil {
+					fmt.Println(command)
+				}
+			} else {
+				res, err = evaluateStatement(params)
 				if err == nil {
-					if operandOnly {
-						// if only number
-						res, err = strconv.ParseFloat(params, 64)
-						if err != nil {
-							err = parseError(err)
-						}
+					result = res.String()
+					if res.uncertainty > 0 {
+						fmt.Println(setBold("="), setBoldValue(res), fmt.Sprintf("(error estimate %.1e)", res.uncertainty))
 					} else {
-						res, err = parseParams(params)
-					}
-					if err == nil {
-						fmt.Println(setBold("="), setBoldFloat(res))
+						fmt.Println(setBold("="), setBoldValue(res))
 					}
 				}
 			}
@@ -503,100 +357,81 @@ func interactiveProcess(params string, term *terminal.Terminal) {
 	}
 
 	if err != nil {
-		fmt.Println(setFgColor(RED, setBoldError(err)))
-		res = math.NaN()
+		fmt.Println(outColors.paint(currentTheme.error, err.Error()))
+		result = fmt.Sprint(math.NaN())
 	}
 
 	if command != "-clear-" {
 		fmt.Println("")
 	}
 
-	isParentheses = false
-
 	// add result to history
-	term.AddResultHistory(res)
+	term.AddResultHistory(result)
 }
 
-// clean input params
-func cleanParams(params string) (string, error) {
-	var err error
-
-	// remove all spaces in expression
-	params = strings.Replace(params, " ", "", -1)
-
-	if isParentheses {
-		params, err = cleanParentheses(params)
-	}
-
-	if params == "" {
-		return params, setError("no params found")
+func parseError(err error) error {
+	errString := err.Error()
+	errSlice := strings.Split(errString, ": ")
+	if len(errSlice) > 2 {
+		return setSyntaxError(errSlice[1]+" - "+errSlice[2], -1)
 	}
-
-	// bringing to a single operator
-	params = strings.Replace(params, ":", "/", -1)
-
-	return params, err
+	return setSyntaxError(errString, -1)
 }
 
-// clean input params
-func cleanParentheses(params string) (string, error) {
-	var err error
+// error classes
+const (
+	syntaxError    = "syntax"
+	mathError      = "math"
+	usageError     = "usage"
+	assertionError = "assertion"
+)
 
-	// check iteration limit
-	if iteration <= 0 {
-		iteration = 1000
-		return  "", setError("iteration limit is reached")
-	}
-	iteration--
+// error of calculation with its class, pos is the offset in the expression
+// or -1 if unknown
+type calcError struct {
+	kind string
+	pos  int
+	msg  string
+}
 
-	prPattern := regexp.MustCompile(`(?:\()([\s\d.]*)(?:\))`)
-	params = prPattern.ReplaceAllStringFunc(params, func(s string) string {
-		p := prPattern.FindStringSubmatch(s)
-		if len(p) == 2 {
-			s = p[1]
-		}
+func (e *calcError) Error() string {
+	return "error: " + e.msg
+}
 
-		return s
-	})
+func setError(text string) error {
+	return &calcError{mathError, -1, text}
+}
 
-	if prPattern.MatchString(params) {
-		return cleanParentheses(params)
-	}
+func setSyntaxError(text string, pos int) error {
+	return &calcError{syntaxError, pos, text}
+}
 
-	return params, err
+func setUsageError(text string) error {
+	return &calcError{usageError, -1, text}
 }
 
-func parseError(err error) error {
-	errString := err.Error()
-	errSlice := strings.Split(errString, ": ")
-	if len(errSlice) > 2 {
-		err = setError(errSlice[1] + " - " + errSlice[2])
+// set position of error if it isn't known yet
+func atPos(err error, pos int) error {
+	if e, ok := err.(*calcError); ok && e.pos < 0 {
+		e.pos = pos
 	}
 	return err
 }
 
-func setError(text string) error {
-	return errors.New("error: " + text)
-}
-
 func setFgColor(color int, text string) string {
-	return fmt.Sprintf("%s%s\033[0m", fmt.Sprintf("\033[3%dm", color), text)
-}
-
-func setBgColor(color int, text string) string {
-	return fmt.Sprintf("%s%s\033[0m", fmt.Sprintf("\033[4%dm", color), text)
+	return outColors.fg(color, text)
 }
 
 func setBold(text string) string {
-	return fmt.Sprintf("\033[1m%s\033[0m", text)
+	return outColors.bold(text)
 }
 
-func setBoldError(err error) string {
-	return fmt.Sprintf("\033[1m%s\033[0m", err)
-}
-
-func setBoldFloat(res float64) string {
-	return fmt.Sprintf("\033[1m%v\033[0m", res)
+// value in result color of the theme, symbolic values are highlighted
+func setBoldValue(res Value) string {
+	if res.symbolic {
+		return highlight(outColors, res.String())
+	}
+	return outColors.paint(currentTheme.result, res.String())
 }
 
 // clear terminal
@@ -604,81 +439,90 @@ func clear() {
 	fmt.Print("\033[H\033[2J")
 }
 
-// check for correct input
-func checkInput(params string) (bool, error) {
-	var err error
-	var wrongSpaces bool
-	var containSymbol bool
-	var invalidSyntax bool
-
-	// check parentheses
-	openPattern := regexp.MustCompile(`\(`)
-	closePattern := regexp.MustCompile(`\)`)
-	openB := openPattern.FindAllString(params, -1)
-	closeB := closePattern.FindAllString(params, -1)
-	if len(openB) != len(closeB) {
-		return false, setError("Invalid syntax: Parentheses mismatch")
-	} else if len(openB) > 0 && len(closeB) > 0 {
-		isParentheses = true
-	}
-
-	// check if expression contains symbols
-	containSymbol, err = regexp.MatchString(`([a-zA-Z]+)`, params)
-	if err != nil {
-		return false, err
-	}
-	if containSymbol {
-		err = setError("Invalid syntax: The expression contains symbols")
-		return false, err
-	}
-
-	// check invalid syntax
-	invalidSyntax, err = regexp.MatchString(`\)[\s\d.]*\(|(\s*[\d.]+\s*)\(|\)(\s*[\d.]+\s*)`, params)
-	if err != nil {
-		return false, err
-	}
-	if invalidSyntax {
-		err = setError("Invalid syntax")
-		return false, err
+func main() {
+	if width, height, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 && height > 0 {
+		screenSize = func() (int, int) { return width, height }
 	}
-
-	// check wrong spaces
-	wrongSpaces, err = regexp.MatchString(`(?:\d|\.)+(\s)+(?:\d|\.)+`, params)
+	command, args, err := parseArgs(os.Args[1:])
+	setupColors()
 	if err != nil {
-		return false, err
-	}
-	if wrongSpaces {
-		return false, setError("Invalid syntax: Wrong space entered")
+		printResult(strings.Join(os.Args[1:], " "), "", output{}, err)
+		os.Exit(exitUsage)
+	}
+	if info != "" {
+		fmt.Print(info)
+		os.Exit(exitOK)
+	}
+
+	switch {
+	case command == "serve":
+		os.Exit(serve())
+	case command == "repl":
+		os.Exit(interactive())
+	case batchPath != "":
+		startOutput()
+		os.Exit(batchFile(batchPath))
+	case command == "eval" || len(args) > 0:
+		// bash mode
+		startOutput()
+		os.Exit(process(args))
+	case !terminal.IsTerminal(int(os.Stdin.Fd())):
+		// batch mode, e.g. cat exprs | icalc
+		startOutput()
+		os.Exit(batch(os.Stdin, "stdin"))
+	default:
+		os.Exit(interactive())
 	}
+}
 
-	// if number only
-	operandOnly, err := regexp.MatchString(`^(\()*(\s)*(\d|\.)+(\.)*(\d)*(\s)*(\))*$`, params)
-
-	return operandOnly, err
+// follow size of the terminal window, the line being edited is redrawn at
+// the new width
+func watchSize(term *terminal.Terminal) {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	go func() {
+		for range resized {
+			width, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+			if err == nil && width > 0 && height > 0 {
+				term.SetSize(width, height)
+			}
+		}
+	}()
 }
 
-func main() {
-	args := os.Args
-	if len(args) > 1 {
-		// bash mode
-		process(args[1])
-		os.Exit(0)
+// interactive mode
+func interactive() int {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		printResult("repl", "", output{}, setUsageError("repl needs a terminal"))
+		return exitUsage
 	}
-
-	// interactive mode
 	clear()
-	fmt.Println(headInfo)
+	fmt.Print(headInfo, "\n")
 	fmt.Println("Type --help for more info")
 
 	term, termErr := terminal.NewWithStdInOut()
 	if termErr != nil {
-		panic(termErr)
+		printResult("repl", "", output{}, setUsageError("repl: "+termErr.Error()))
+		return exitUsage
 	}
 	defer term.ReleaseFromStdInOut() // defer this
+	screenSize = term.Size
+	watchSize(term)
 	fmt.Println("")
 
-	termFText := setBgColor(CYAN, setFgColor(YELLOW, setBold(termText))) + " "
-	term.SetPrompt(termFText)
+	configureTerminal(term)
+	// preview is written plain with NO_COLOR or --color never
+	if outColors.escape == nil {
+		term.Escape = &terminal.EscapeCodes{}
+	}
+	term.AutoCompleteCallback = completer(term)
+	term.Highlighter = func(line []byte, cursor int) []byte {
+		return highlightInput(outColors, line, cursor)
+	}
+	term.PreviewCallback = previewResult
+	if err := loadHistory(term); err != nil {
+		fmt.Println(outColors.paint(currentTheme.error, err.Error()))
+	}
 
 	for {
 		line, err := term.ReadLine()
@@ -687,10 +531,16 @@ func main() {
 				exitCommand(term)
 			} else {
 				fmt.Println("error: ", err)
+				if err := saveHistory(term); err != nil {
+					fmt.Println(outColors.paint(currentTheme.error, err.Error()))
+				}
 				break
 			}
 		}
 		text := strings.TrimSpace(line)
+		calcLock.Lock()
 		interactiveProcess(text, term)
+		calcLock.Unlock()
 	}
-}
\ No newline at end of file
+	return exitOK
+}
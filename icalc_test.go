@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var previewTests = []struct {
+	in, out string
+}{
+	{"1 + 2", "= 3"},
+	{"x = 2^10", "= 1024"},
+	{"sqrt(16)", "= 4"},
+	{"sum(k, k, 1, 100)", "= 5050"},
+	{"fast(3, 4)", "= 5"},
+	// nothing new to show
+	{"42", ""},
+	{"", ""},
+	// incomplete and commands
+	{"2 +", ""},
+	{":set vi", ""},
+	{"--help", ""},
+	{"solve x^2 = 4", ""},
+	{"plot sin(x)", ""},
+	// over the time of a preview
+	{"sum(sum(k, k, 1, 10^6), j, 1, 10^6)", ""},
+	{"1 + slow(10^6)", ""},
+	{"isprime(3^10000 + 2) + 1", ""},
+}
+
+func TestPreviewResult(t *testing.T) {
+	for signature, body := range map[string]string{
+		"slow(n)":    "sum(sum(k, k, 1, n), j, 1, n) + 1",
+		"fast(a, b)": "sqrt(a^2 + b^2)",
+	} {
+		if err := defineFunction(signature, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for _, name := range []string{"slow", "fast"} {
+			delete(functions, name)
+			delete(userFunctions, name)
+		}
+	}()
+
+	resetVariables()
+	for _, test := range previewTests {
+		start := time.Now()
+		if out := previewResult(test.in); out != test.out {
+			t.Errorf("previewResult(%q) = %q, expected %q", test.in, out, test.out)
+		}
+		// slow preview stops at its next step, a slow builtin call is left
+		// in the background
+		calcLock.Lock()
+		calcLock.Unlock()
+		if d := time.Since(start); d > 10*previewTimeout {
+			t.Errorf("previewResult(%q) took %v", test.in, d)
+		}
+	}
+	// preview of assignment doesn't store the value
+	if _, ok := variables["x"]; ok {
+		t.Errorf("preview stored variable x")
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"math/big"
+)
+
+// rounds of Miller-Rabin test, big.Int also runs Baillie-PSW
+const primeRounds = 20
+
+var (
+	bigOne = big.NewInt(1)
+	bigTwo = big.NewInt(2)
+)
+
+func init() {
+	functions["gcd"] = function{2, -1, gcd, "gcd(a, b, ...)", "greatest common divisor"}
+	functions["lcm"] = function{2, -1, lcm, "lcm(a, b, ...)", "least common multiple"}
+	functions["mod"] = function{2, 2, modFunc, "mod(a, m)", "modulo with non-negative result"}
+	functions["isprime"] = function{1, 1, isPrime, "isprime(n)", "1 if n is prime, 0 otherwise"}
+	functions["nextprime"] = function{1, 1, nextPrime, "nextprime(n)", "smallest prime greater than n"}
+	functions["factor"] = function{1, 1, factor, "factor(n)", "prime factorisation of n"}
+	functions["modinv"] = function{2, 2, modInverse, "modinv(a, m)", "modular multiplicative inverse of a"}
+	functions["powmod"] = function{3, 3, powMod, "powmod(b, e, m)", "b^e modulo m"}
+}
+
+// convert arguments to integers
+func intArgs(name string, args []Value) ([]*big.Int, error) {
+	result := make([]*big.Int, len(args))
+	for index, arg := range args {
+		i, ok := arg.Int()
+		if !ok {
+			return nil, setError(name + ": arguments must be integers")
+		}
+		result[index] = i
+	}
+	return result, nil
+}
+
+func gcd(args []Value) (Value, error) {
+	nums, err := intArgs("gcd", args)
+	if err != nil {
+		return Value{}, err
+	}
+	result := new(big.Int).Abs(nums[0])
+	for _, num := range nums[1:] {
+		result.GCD(nil, nil, result, new(big.Int).Abs(num))
+	}
+	return intValue(result), nil
+}
+
+func lcm(args []Value) (Value, error) {
+	nums, err := intArgs("lcm", args)
+	if err != nil {
+		return Value{}, err
+	}
+	result := new(big.Int).Abs(nums[0])
+	for _, num := range nums[1:] {
+		if result.Sign() == 0 || num.Sign() == 0 {
+			return int64Value(0), nil
+		}
+		d := new(big.Int).GCD(nil, nil, result, new(big.Int).Abs(num))
+		result.Mul(result, new(big.Int).Abs(num))
+		result.Quo(result, d)
+	}
+	return intValue(result), nil
+}
+
+func modFunc(args []Value) (Value, error) {
+	nums, err := intArgs("mod", args)
+	if err != nil {
+		return Value{}, err
+	}
+	if nums[1].Sign() == 0 {
+		return Value{}, setError("Modulo by zero")
+	}
+	m := new(big.Int).Abs(nums[1])
+	return intValue(new(big.Int).Mod(nums[0], m)), nil
+}
+
+func isPrime(args []Value) (Value, error) {
+	nums, err := intArgs("isprime", args)
+	if err != nil {
+		return Value{}, err
+	}
+	if nums[0].ProbablyPrime(primeRounds) {
+		return int64Value(1), nil
+	}
+	return int64Value(0), nil
+}
+
+func nextPrime(args []Value) (Value, error) {
+	nums, err := intArgs("nextprime", args)
+	if err != nil {
+		return Value{}, err
+	}
+	n := nums[0]
+	if n.Cmp(bigTwo) < 0 {
+		return int64Value(2), nil
+	}
+	// start from the next odd number
+	result := new(big.Int).Add(n, bigOne)
+	if result.Bit(0) == 0 {
+		result.Add(result, bigOne)
+	}
+	for !result.ProbablyPrime(primeRounds) {
+		result.Add(result, bigTwo)
+	}
+	return intValue(result), nil
+}
+
+func factor(args []Value) (Value, error) {
+	nums, err := intArgs("factor", args)
+	if err != nil {
+		return Value{}, err
+	}
+	n := nums[0]
+	if n.Sign() == 0 {
+		return Value{}, setError("factor: zero has no factorisation")
+	}
+
+	primes, err := primeFactors(new(big.Int).Abs(n))
+	if err != nil {
+		return Value{}, err
+	}
+
+	// build product of prime powers, e.g. 2^3*3^2*5
+	var product Node
+	for i := 0; i < len(primes); {
+		j := i
+		for j < len(primes) && primes[j].Cmp(primes[i]) == 0 {
+			j++
+		}
+		var f Node = &numberNode{value: intValue(primes[i])}
+		if j-i > 1 {
+			f = &binaryNode{op: "^", left: f, right: &numberNode{value: int64Value(int64(j - i))}}
+		}
+		if product == nil {
+			product = f
+		} else {
+			product = &binaryNode{op: "*", left: product, right: f}
+		}
+		i = j
+	}
+	if product == nil {
+		product = &numberNode{value: int64Value(1)}
+	}
+	if n.Sign() < 0 {
+		product = &unaryNode{op: "-", x: product}
+	}
+	return exprValue(product, intValue(n)), nil
+}
+
+// sorted prime factors of n > 0 with repetitions
+func primeFactors(n *big.Int) ([]*big.Int, error) {
+	var primes []*big.Int
+	n = new(big.Int).Set(n)
+
+	// trial division by small primes
+	for p := int64(2); p < 1000; p++ {
+		d := big.NewInt(p)
+		if !d.ProbablyPrime(0) {
+			continue
+		}
+		r := new(big.Int)
+		for {
+			q, _ := new(big.Int).QuoRem(n, d, r)
+			if r.Sign() != 0 {
+				break
+			}
+			primes = append(primes, d)
+			n = q
+		}
+	}
+
+	// split the rest with Pollard's rho
+	rest := []*big.Int{n}
+	for len(rest) > 0 {
+		m := rest[len(rest)-1]
+		rest = rest[:len(rest)-1]
+		if m.Cmp(bigOne) == 0 {
+			continue
+		}
+		if m.ProbablyPrime(primeRounds) {
+			primes = append(primes, m)
+			continue
+		}
+		d := pollardRho(m)
+		if d == nil {
+			return nil, setError("factor: " + m.String() + " is too big to factor")
+		}
+		rest = append(rest, d, new(big.Int).Quo(m, d))
+	}
+
+	sortInts(primes)
+	return primes, nil
+}
+
+// max steps of Pollard's rho before giving up
+const rhoSteps = 1 << 20
+
+// polynomials x^2 + c tried by Pollard's rho
+const rhoPolynomials = 10
+
+// Pollard's rho, returns nontrivial divisor of composite n or nil
+func pollardRho(n *big.Int) *big.Int {
+	for c := int64(1); c <= rhoPolynomials; c++ {
+		// each polynomial starts from another value
+		x := big.NewInt(c + 1)
+		y := big.NewInt(c + 1)
+		d := big.NewInt(1)
+		step := func(v *big.Int) {
+			v.Mul(v, v)
+			v.Add(v, big.NewInt(c))
+			v.Mod(v, n)
+		}
+		diff := new(big.Int)
+		i := 0
+		for ; d.Cmp(bigOne) == 0 && i < rhoSteps; i++ {
+			step(x)
+			step(y)
+			step(y)
+			diff.Sub(x, y)
+			d.GCD(nil, nil, diff.Abs(diff), n)
+		}
+		if d.Cmp(bigOne) != 0 && d.Cmp(n) != 0 {
+			return d
+		}
+		// out of steps or a cycle without divisor, retry with another
+		// polynomial
+	}
+	return nil
+}
+
+func sortInts(nums []*big.Int) {
+	for i := 1; i < len(nums); i++ {
+		for j := i; j > 0 && nums[j].Cmp(nums[j-1]) < 0; j-- {
+			nums[j], nums[j-1] = nums[j-1], nums[j]
+		}
+	}
+}
+
+func modInverse(args []Value) (Value, error) {
+	nums, err := intArgs("modinv", args)
+	if err != nil {
+		return Value{}, err
+	}
+	a, m := nums[0], new(big.Int).Abs(nums[1])
+	if m.Sign() == 0 {
+		return Value{}, setError("Modulo by zero")
+	}
+	result := new(big.Int).ModInverse(new(big.Int).Mod(a, m), m)
+	if result == nil {
+		return Value{}, setError("modinv: " + a.String() + " has no inverse modulo " + m.String())
+	}
+	return intValue(result), nil
+}
+
+func powMod(args []Value) (Value, error) {
+	nums, err := intArgs("powmod", args)
+	if err != nil {
+		return Value{}, err
+	}
+	b, e, m := nums[0], nums[1], new(big.Int).Abs(nums[2])
+	if m.Sign() == 0 {
+		return Value{}, setError("Modulo by zero")
+	}
+	b = new(big.Int).Mod(b, m)
+	if e.Sign() < 0 {
+		// negative exponent is a power of the inverse
+		b = b.ModInverse(b, m)
+		if b == nil {
+			return Value{}, setError("powmod: " + nums[0].String() + " has no inverse modulo " + m.String())
+		}
+		e = new(big.Int).Neg(e)
+	}
+	return intValue(new(big.Int).Exp(b, e, m)), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+var numTheoryTests = []struct {
+	in, out string
+}{
+	{"gcd(12, 18)", "6"},
+	{"gcd(-12, 18, 27)", "3"},
+	{"gcd(0, 5)", "5"},
+	{"lcm(4, 6)", "12"},
+	{"lcm(2, 3, 4)", "12"},
+	{"lcm(0, 5)", "0"},
+	{"mod(7, 3)", "1"},
+	{"mod(-7, 3)", "2"},
+	{"mod(7, -3)", "1"},
+	{"isprime(2)", "1"},
+	{"isprime(1)", "0"},
+	{"isprime(2^61 - 1)", "1"},
+	{"isprime(2^64 + 1)", "0"},
+	{"nextprime(0)", "2"},
+	{"nextprime(2)", "3"},
+	{"nextprime(13)", "17"},
+	{"nextprime(10^18)", "1000000000000000003"},
+	{"factor(1)", "1"},
+	{"factor(360)", "2^3*3^2*5"},
+	{"factor(-12)", "-(2^2*3)"},
+	{"factor(2^64 + 1)", "274177*67280421310721"},
+	{"factor(1000000007 * 998244353)", "998244353*1000000007"},
+	{"modinv(3, 11)", "4"},
+	{"modinv(-3, 11)", "7"},
+	{"powmod(2, 10, 1000)", "24"},
+	{"powmod(3, -1, 11)", "4"},
+	{"powmod(2, 2^100, 97)", "61"},
+}
+
+func TestNumberTheory(t *testing.T) {
+	resetVariables()
+	for _, test := range numTheoryTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.in, err)
+			continue
+		}
+		if res.String() != test.out {
+			t.Errorf("%s = %s, expected %s", test.in, res, test.out)
+		}
+	}
+}
+
+var numTheoryErrorTests = []string{
+	"gcd(1.5, 3)",
+	"mod(7, 0)",
+	"factor(0)",
+	"modinv(2, 4)",
+	"modinv(2, 0)",
+	"powmod(2, -1, 4)",
+	"isprime(0.5)",
+}
+
+func TestNumberTheoryErrors(t *testing.T) {
+	resetVariables()
+	for _, in := range numTheoryErrorTests {
+		_, err := evaluate(in)
+		if err == nil {
+			t.Errorf("%s: expected error", in)
+			continue
+		}
+		if kind := errorOf(err).kind; kind != mathError {
+			t.Errorf("%s: got %s error %v, expected %s", in, kind, err, mathError)
+		}
+	}
+}
+
+func TestPollardRho(t *testing.T) {
+	// x^2 + 1 finds no divisor of these, other polynomials do
+	for _, n := range []int64{21, 25, 95, 169, 341, 1681} {
+		d := pollardRho(big.NewInt(n))
+		if d == nil || d.Cmp(bigOne) == 0 || new(big.Int).Rem(big.NewInt(n), d).Sign() != 0 {
+			t.Errorf("pollardRho(%d) = %v, expected nontrivial divisor", n, d)
+		}
+	}
+}
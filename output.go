@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// output formats of bash and batch modes
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+	csvOutput  = "csv"
+)
+
+var outputFormat = textOutput
+
+// don't print error messages, failure is reported by exit code
+var quiet = false
+
+// print results of all statements in bash mode and of assignments, not only
+// the last one
+var printAll = false
+
+// result of a statement
+type output struct {
+	// text printed in text format, empty for silent statements
+	text string
+	// calculated value, nil for commands
+	value *Value
+	// assignments are printed in text format only with --all
+	assignment bool
+}
+
+// result of a statement in json format, one object per line
+type jsonResult struct {
+	Expr   string     `json:"expr"`
+	Result *string    `json:"result"`
+	Type   *string    `json:"type"`
+	Error  *jsonError `json:"error"`
+}
+
+type jsonError struct {
+	Kind string `json:"kind"`
+	// offset in expr, null if unknown
+	Pos *int   `json:"pos"`
+	Msg string `json:"msg"`
+}
+
+var csvWriter = csv.NewWriter(os.Stdout)
+
+// set output format from option
+func setOutputFormat(format string) error {
+	switch format {
+	case textOutput, jsonOutput, csvOutput:
+		outputFormat = format
+		return nil
+	}
+	return setUsageError("unknown output format " + format + ", expected text, json or csv")
+}
+
+// print header of the output format before results
+func startOutput() {
+	if outputFormat == csvOutput {
+		_ = csvWriter.Write([]string{"expr", "result", "type", "error_kind", "error_pos", "error_msg"})
+		csvWriter.Flush()
+	}
+}
+
+// print result of statement expr, where is the location of the statement
+// printed before errors in text format, e.g. "loan.icalc:3: "
+func printResult(expr, where string, out output, err error) {
+	switch outputFormat {
+	case jsonOutput:
+		printJSON(expr, out, err)
+	case csvOutput:
+		printCSV(expr, out, err)
+	default:
+		if err != nil {
+			if !quiet {
+				fmt.Fprintln(os.Stderr, errColors.paint(currentTheme.error, where+err.Error()))
+			}
+		} else if out.text != "" && (!out.assignment || printAll) {
+			fmt.Println(out.text)
+		}
+	}
+}
+
+func printJSON(expr string, out output, err error) {
+	line, _ := json.Marshal(jsonOf(expr, out, err))
+	fmt.Println(string(line))
+}
+
+func jsonOf(expr string, out output, err error) jsonResult {
+	res := jsonResult{Expr: expr}
+	if err != nil {
+		e := errorOf(err)
+		res.Error = &jsonError{Kind: e.kind, Msg: e.msg}
+		if e.pos >= 0 {
+			res.Error.Pos = &e.pos
+		}
+	} else {
+		result, kind := resultOf(out)
+		res.Result, res.Type = &result, &kind
+	}
+	return res
+}
+
+func printCSV(expr string, out output, err error) {
+	record := []string{expr, "", "", "", "", ""}
+	if err != nil {
+		e := errorOf(err)
+		record[3], record[5] = e.kind, e.msg
+		if e.pos >= 0 {
+			record[4] = strconv.Itoa(e.pos)
+		}
+	} else {
+		record[1], record[2] = resultOf(out)
+	}
+	_ = csvWriter.Write(record)
+	csvWriter.Flush()
+}
+
+// result text and its type: integer, float, symbolic or text of commands
+func resultOf(out output) (string, string) {
+	if out.value == nil {
+		return out.text, "text"
+	}
+	v := *out.value
+	switch {
+	case v.symbolic:
+		return v.String(), "symbolic"
+	case v.IsInt():
+		// plain number instead of a form like factorisation
+		return Value{i: v.i}.String(), "integer"
+	}
+	return Value{f: v.f}.String(), "float"
+}
+
+// error with its class, errors from outside of calculations are usage errors
+func errorOf(err error) *calcError {
+	if e, ok := err.(*calcError); ok {
+		return e
+	}
+	return &calcError{usageError, -1, strings.TrimPrefix(err.Error(), "error: ")}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const outputInput = "1+1\nfactor(12)\ndiff(x^2,x)\n1/4\n1/0\n2 +\nprint 5\n"
+
+// run batch in output format, returns stdout and stderr
+func batchOutput(t *testing.T, format, in string) (string, string) {
+	t.Helper()
+	saved := outputFormat
+	outputFormat = format
+	defer func() { outputFormat = saved }()
+	resetVariables()
+	return captureOutput(t, func() {
+		startOutput()
+		batch(strings.NewReader(in), "stdin")
+	})
+}
+
+func TestTextOutput(t *testing.T) {
+	out, errText := batchOutput(t, textOutput, outputInput)
+	if want := "2\n2^2*3\n2*x\n0.25\n5\n"; out != want {
+		t.Errorf("stdout %q, expected %q", out, want)
+	}
+	want := "stdin:5: error: you tried to divide by zero\nstdin:6: error: not enough arguments\n"
+	if errText != want {
+		t.Errorf("stderr %q, expected %q", errText, want)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	out, errText := batchOutput(t, jsonOutput, outputInput)
+	want := `{"expr":"1+1","result":"2","type":"integer","error":null}
+{"expr":"factor(12)","result":"12","type":"integer","error":null}
+{"expr":"diff(x^2,x)","result":"2*x","type":"symbolic","error":null}
+{"expr":"1/4","result":"0.25","type":"float","error":null}
+{"expr":"1/0","result":null,"type":null,"error":{"kind":"math","pos":1,"msg":"you tried to divide by zero"}}
+{"expr":"2 +","result":null,"type":null,"error":{"kind":"syntax","pos":3,"msg":"not enough arguments"}}
+{"expr":"print 5","result":"5","type":"text","error":null}
+`
+	if out != want {
+		t.Errorf("stdout\n%s\nexpected\n%s", out, want)
+	}
+	if errText != "" {
+		t.Errorf("stderr %q, expected nothing", errText)
+	}
+}
+
+func TestCSVOutput(t *testing.T) {
+	out, errText := batchOutput(t, csvOutput, outputInput+"print \"a, b\"\n")
+	want := `expr,result,type,error_kind,error_pos,error_msg
+1+1,2,integer,,,
+factor(12),12,integer,,,
+"diff(x^2,x)",2*x,symbolic,,,
+1/4,0.25,float,,,
+1/0,,,math,1,you tried to divide by zero
+2 +,,,syntax,3,not enough arguments
+print 5,5,text,,,
+"print ""a, b""","a, b",text,,,
+`
+	if out != want {
+		t.Errorf("stdout\n%s\nexpected\n%s", out, want)
+	}
+	if errText != "" {
+		t.Errorf("stderr %q, expected nothing", errText)
+	}
+}
+
+func TestSetOutputFormat(t *testing.T) {
+	saved := outputFormat
+	defer func() { outputFormat = saved }()
+	for _, format := range []string{textOutput, jsonOutput, csvOutput} {
+		if err := setOutputFormat(format); err != nil || outputFormat != format {
+			t.Errorf("setOutputFormat(%s): %v", format, err)
+		}
+	}
+	if err := setOutputFormat("xml"); err == nil || errorOf(err).kind != usageError {
+		t.Errorf("setOutputFormat(xml) = %v, expected usage error", err)
+	}
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos and end are byte offsets of the token in the input, text differs
+	// from it for unicode operators, e.g. × is *
+	pos, end int
+	// true if token is preceded by a space
+	space bool
+}
+
+// Node is an element of a parsed expression tree
+type Node interface {
+	String() string
+}
+
+type numberNode struct {
+	value Value
+	pos   int
+}
+
+type identNode struct {
+	name string
+	pos  int
+}
+
+type unaryNode struct {
+	op  string
+	x   Node
+	pos int
+}
+
+type binaryNode struct {
+	op          string
+	left, right Node
+	pos         int
+}
+
+type callNode struct {
+	name string
+	args []Node
+	pos  int
+}
+
+// operators precedence, higher binds tighter
+var precedence = map[string]int{
+	"+": 1,
+	"-": 1,
+	"*": 2,
+	"/": 2,
+	"%": 2,
+	"^": 4,
+}
+
+// precedence of unary minus
+const unaryPrecedence = 3
+
+func nodePrecedence(n Node) int {
+	switch n := n.(type) {
+	case *binaryNode:
+		return precedence[n.op]
+	case *unaryNode:
+		return unaryPrecedence
+	case *numberNode:
+		if n.value.expr != nil {
+			return nodePrecedence(n.value.expr)
+		}
+		if n.value.Float() < 0 {
+			return unaryPrecedence
+		}
+	}
+	return 5
+}
+
+func (n *numberNode) String() string {
+	return n.value.String()
+}
+
+func (n *identNode) String() string {
+	return n.name
+}
+
+func (n *unaryNode) String() string {
+	x := n.x.String()
+	if nodePrecedence(n.x) <= unaryPrecedence {
+		x = "(" + x + ")"
+	}
+	return n.op + x
+}
+
+func (n *binaryNode) String() string {
+	prec := precedence[n.op]
+	left := n.left.String()
+	lp := nodePrecedence(n.left)
+	if lp < prec {
+		left = "(" + left + ")"
+	}
+	right := n.right.String()
+	rp := nodePrecedence(n.right)
+	if rp < prec || rp == unaryPrecedence ||
+		rp == prec && !(n.op == "+" || n.op == "*") {
+		right = "(" + right + ")"
+	} else if rp == prec {
+		// a+(b-c) and a*(b/c) still need parentheses
+		if r, ok := n.right.(*binaryNode); ok && r.op != n.op {
+			right = "(" + right + ")"
+		}
+	}
+	if n.op == "+" || n.op == "-" {
+		return left + " " + n.op + " " + right
+	}
+	return left + n.op + right
+}
+
+func (n *callNode) String() string {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.String()
+	}
+	return n.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// names of identifiers used in expression, in order of appearance
+func identifiers(n Node) []string {
+	var names []string
+	seen := map[string]bool{}
+	var walk func(n Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *identNode:
+			if !seen[n.name] {
+				seen[n.name] = true
+				names = append(names, n.name)
+			}
+		case *unaryNode:
+			walk(n.x)
+		case *binaryNode:
+			walk(n.left)
+			walk(n.right)
+		case *callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(n)
+	return names
+}
+
+// unicode operators typed instead of ASCII ones, √ is a prefix square root,
+// ² and ³ are postfix powers
+var unicodeOperators = map[rune]string{
+	'×': "*",
+	'÷': "/",
+	'−': "-",
+	'√': "√",
+	'²': "²",
+	'³': "³",
+}
+
+// split expression into tokens
+func tokenize(params string) ([]token, error) {
+	var tokens []token
+	space := false
+	for i := 0; i < len(params); {
+		c, size := utf8.DecodeRuneInString(params[i:])
+		start := i
+		i += size
+		switch {
+		case c == ' ' || c == '\t':
+			space = true
+			continue
+		case c >= '0' && c <= '9' || c == '.':
+			for i < len(params) && (params[i] >= '0' && params[i] <= '9' || params[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, params[start:i], start, i, space})
+		case unicode.IsLetter(c) || c == '_':
+			for i < len(params) {
+				c, size := utf8.DecodeRuneInString(params[i:])
+				if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+					break
+				}
+				i += size
+			}
+			name := params[start:i]
+			if name == "π" {
+				name = "pi"
+			}
+			tokens = append(tokens, token{tokenIdent, name, start, i, space})
+		case strings.ContainsRune("+-*/:^%", c):
+			op := string(c)
+			if op == ":" {
+				// bringing to a single operator
+				op = "/"
+			}
+			tokens = append(tokens, token{tokenOperator, op, start, i, space})
+		case unicodeOperators[c] != "":
+			tokens = append(tokens, token{tokenOperator, unicodeOperators[c], start, i, space})
+		case c == '(':
+			tokens = append(tokens, token{tokenOpen, "(", start, i, space})
+		case c == ')':
+			tokens = append(tokens, token{tokenClose, ")", start, i, space})
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", start, i, space})
+		default:
+			return tokens, setSyntaxError("Invalid syntax: unexpected symbol '"+string(c)+"'", start)
+		}
+		space = false
+	}
+	tokens = append(tokens, token{tokenEOF, "", len(params), len(params), space})
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	depth  int
+}
+
+// parse expression into a tree
+func parse(params string) (Node, error) {
+	p, err := newParser(params)
+	if err != nil {
+		return nil, err
+	}
+	node, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return node, nil
+}
+
+// parse comma separated expressions, e.g. sin(x), x, -pi, pi
+func parseList(params string) ([]Node, error) {
+	p, err := newParser(params)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for {
+		node, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if p.peek().kind != tokenComma {
+			break
+		}
+		p.take()
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return nodes, nil
+}
+
+// position of the first closing parenthesis without opening one or of the
+// last opening one without closing, -1 if parentheses match
+func unmatchedParenthesis(params string) int {
+	var open []int
+	for i, c := range params {
+		switch c {
+		case '(':
+			open = append(open, i)
+		case ')':
+			if len(open) == 0 {
+				return i
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return open[len(open)-1]
+	}
+	return -1
+}
+
+func newParser(params string) (*parser, error) {
+	if strings.TrimSpace(params) == "" {
+		return nil, setSyntaxError("no params found", -1)
+	}
+	if pos := unmatchedParenthesis(params); pos >= 0 {
+		return nil, setSyntaxError("Invalid syntax: Parentheses mismatch", pos)
+	}
+	tokens, err := tokenize(params)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens}, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// check iteration limit
+func (p *parser) nest() error {
+	p.depth++
+	if p.depth > iterationLimit {
+		return setSyntaxError("iteration limit is reached", p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) unnest() {
+	p.depth--
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if p.next > 0 {
+		prev := p.tokens[p.next-1]
+		if prev.kind == tokenNumber && t.kind == tokenNumber && t.space {
+			return setSyntaxError("Invalid syntax: Wrong space entered", t.pos)
+		}
+	}
+	return setSyntaxError("Invalid syntax", t.pos)
+}
+
+// expression: term (('+'|'-') term)*
+func (p *parser) parseExpression() (Node, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokenOperator && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.take()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{t.text, left, right, t.pos}
+	}
+	return left, nil
+}
+
+// term: unary (('*'|'/'|'%') unary)*
+func (p *parser) parseTerm() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokenOperator && (t.text == "*" || t.text == "/" || t.text == "%"); t = p.peek() {
+		p.take()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{t.text, left, right, t.pos}
+	}
+	return left, nil
+}
+
+// unary: ('-'|'+'|'√') unary | power
+func (p *parser) parseUnary() (Node, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && (t.text == "-" || t.text == "+" || t.text == "√") {
+		if err := p.nest(); err != nil {
+			return nil, err
+		}
+		defer p.unnest()
+
+		p.take()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch t.text {
+		case "+":
+			return x, nil
+		case "√":
+			return &callNode{name: "sqrt", args: []Node{x}, pos: t.pos}, nil
+		}
+		return &unaryNode{t.text, x, t.pos}, nil
+	}
+	return p.parsePower()
+}
+
+// power: primary ('^' primary | '²' | '³')*, calculated from left to right
+func (p *parser) parsePower() (Node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokenOperator && (t.text == "^" || t.text == "²" || t.text == "³"); t = p.peek() {
+		p.take()
+		if t.text != "^" {
+			exp := int64(2)
+			if t.text == "³" {
+				exp = 3
+			}
+			left = &binaryNode{"^", left, &numberNode{intValue(big.NewInt(exp)), t.pos}, t.pos}
+			if err := p.checkOperand(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var right Node
+		if n := p.peek(); n.kind == tokenOperator && (n.text == "-" || n.text == "√") {
+			// allow negative exponent 2^-1 and root 2^√2
+			right, err = p.parseUnary()
+		} else {
+			right, err = p.parsePrimary()
+		}
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{t.text, left, right, t.pos}
+	}
+	return left, nil
+}
+
+// primary: number | ident | ident '(' args ')' | '(' expression ')'
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.take()
+		value, err := parseNumber(t.text)
+		if err != nil {
+			return nil, atPos(err, t.pos)
+		}
+		return &numberNode{value, t.pos}, p.checkOperand()
+	case tokenIdent:
+		p.take()
+		if p.peek().kind != tokenOpen {
+			return &identNode{t.text, t.pos}, p.checkOperand()
+		}
+		p.take()
+		call := &callNode{name: t.text, pos: t.pos}
+		if p.peek().kind == tokenClose {
+			p.take()
+			return call, p.checkOperand()
+		}
+		for {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.take()
+		}
+		if p.peek().kind != tokenClose {
+			return nil, p.unexpected()
+		}
+		p.take()
+		return call, p.checkOperand()
+	case tokenOpen:
+		p.take()
+		x, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenClose {
+			return nil, p.unexpected()
+		}
+		p.take()
+		return x, p.checkOperand()
+	case tokenEOF, tokenOperator:
+		return nil, setSyntaxError("not enough arguments", t.pos)
+	}
+	return nil, p.unexpected()
+}
+
+// operand can't be followed by another operand, e.g. 2(3) or 2 3
+func (p *parser) checkOperand() error {
+	switch p.peek().kind {
+	case tokenNumber, tokenIdent, tokenOpen:
+		return p.unexpected()
+	}
+	return nil
+}
+
+// convert number literal to value
+func parseNumber(text string) (Value, error) {
+	if !strings.Contains(text, ".") {
+		if i, ok := new(big.Int).SetString(text, 10); ok {
+			return intValue(i), nil
+		}
+	}
+	parsed, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Value{}, parseError(err)
+	}
+	return floatValue(parsed), nil
+}
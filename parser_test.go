@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+var evaluateTests = []struct {
+	in, out string
+}{
+	{"1 + 2", "3"},
+	{"2 * (3 + 4)", "14"},
+	{"10 / 2", "5"},
+	{"1 / 4", "0.25"},
+	{"10 : 4", "2.5"},
+	{"7 % 3", "1"},
+	{"2^10", "1024"},
+	{"2^-1", "0.5"},
+	{"2^100", "1267650600228229401496703205376"},
+	{"2^64 - 1", "18446744073709551615"},
+	{"2 × 3 − 1", "5"},
+	{"12 ÷ 4", "3"},
+	{"√16", "4"},
+	{"3²", "9"},
+	{"2³", "8"},
+	{"1.5 * 4", "6"},
+	{"abs(-3)", "3"},
+	{"  4  ", "4"},
+}
+
+func TestEvaluate(t *testing.T) {
+	resetVariables()
+	for _, test := range evaluateTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.in, err)
+			continue
+		}
+		if res.String() != test.out {
+			t.Errorf("%q = %s, expected %s", test.in, res, test.out)
+		}
+	}
+}
+
+var precedenceTests = []struct {
+	in, out string
+}{
+	// ^ binds tighter than unary minus
+	{"-2^2", "-4"},
+	{"(-2)^2", "4"},
+	{"-2^-2", "-0.25"},
+	{"2 + 3 * 4", "14"},
+	{"2 * 3^2", "18"},
+	{"10 - 4 - 3", "3"},
+	{"2^3^2", "64"},
+	{"-√4", "-2"},
+	{"--3", "3"},
+	{"-3²", "-9"},
+	{"2^3²", "64"},
+}
+
+func TestPrecedence(t *testing.T) {
+	resetVariables()
+	for _, test := range precedenceTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.in, err)
+			continue
+		}
+		if res.String() != test.out {
+			t.Errorf("%q = %s, expected %s", test.in, res, test.out)
+		}
+	}
+}
+
+var parseErrorTests = []struct {
+	in   string
+	kind string
+}{
+	{"", syntaxError},
+	{"2 +", syntaxError},
+	{"(1", syntaxError},
+	{"1)", syntaxError},
+	{"2 $ 3", syntaxError},
+	{"1 / 0", mathError},
+	{"nosuchfunction(1)", syntaxError},
+}
+
+func TestParseErrors(t *testing.T) {
+	resetVariables()
+	for _, test := range parseErrorTests {
+		_, err := evaluate(test.in)
+		if err == nil {
+			t.Errorf("%q: expected %s error", test.in, test.kind)
+			continue
+		}
+		if kind := errorOf(err).kind; kind != test.kind {
+			t.Errorf("%q: got %s error %v, expected %s", test.in, kind, err, test.kind)
+		}
+	}
+}
+
+func TestMaxIntBits(t *testing.T) {
+	limit := new(big.Int).Lsh(bigOne, maxIntBits-1)
+	if v := intValue(limit); !v.IsInt() {
+		t.Errorf("integer of %d bits isn't exact", limit.BitLen())
+	}
+	over := new(big.Int).Lsh(bigOne, maxIntBits)
+	if v := intValue(over); v.IsInt() {
+		t.Errorf("integer of %d bits is exact, expected float", over.BitLen())
+	}
+
+	// exact up to the limit, float beyond it
+	res, err := evaluate("2^30000")
+	if err != nil || !res.IsInt() || res.i.BitLen() != 30001 {
+		t.Errorf("2^30000 = %v, %v, expected exact integer", res, err)
+	}
+	// powers are exact up to the limit too
+	for _, in := range []string{"2^32769", "2^65535", "(-2)^65535", "3^41000"} {
+		res, err = evaluate(in)
+		if err != nil || !res.IsInt() {
+			t.Errorf("%s = %.20v..., %v, expected exact integer", in, res, err)
+		}
+	}
+	res, err = evaluate("2^30000 * 2^30000 * 2^30000")
+	if err != nil || res.IsInt() {
+		t.Errorf("2^90000 = %v, %v, expected float", res, err)
+	}
+	res, err = evaluate("2^100000")
+	if err != nil || res.IsInt() || res.String() != "+Inf" {
+		t.Errorf("2^100000 = %v, %v, expected +Inf", res, err)
+	}
+	// powers of 0, 1 and -1 stay exact
+	res, err = evaluate("(-1)^100001")
+	if err != nil || res.String() != "-1" {
+		t.Errorf("(-1)^100001 = %v, %v, expected -1", res, err)
+	}
+}
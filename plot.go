@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// range of the variable if it isn't given
+const plotRange = 10.0
+
+// width of y axis labels
+const plotLabelWidth = 10
+
+// smallest chart in characters
+const (
+	minPlotWidth  = 10
+	minPlotHeight = 4
+)
+
+// colors of plotted functions in order
+var plotColors = []int{GREEN, YELLOW, MAGENTA, CYAN, RED, BLUE}
+
+// width and height of the screen in characters, replaced by main with the
+// size known to the terminal
+var screenSize = func() (int, int) { return 80, 24 }
+
+func init() {
+	lineCommands["plot"] = lineCommand{plot, "plot f, [g, ...], [x, a, b]", "chart of functions of x from a to b, -10..10 by default"}
+}
+
+// plot sin(x), cos(x), x, -pi, pi
+func plot(rest string) (string, error) {
+	nodes, err := parseList(rest)
+	if err != nil {
+		return "", err
+	}
+
+	name := ""
+	a, b := -plotRange, plotRange
+	if n := len(nodes); n >= 4 {
+		if x, ok := nodes[n-3].(*identNode); ok {
+			name = x.name
+			if a, b, err = plotBounds(nodes[n-2], nodes[n-1]); err != nil {
+				return "", err
+			}
+			nodes = nodes[:n-3]
+		}
+	}
+	if name == "" {
+		unknown := unknownIdentifiers(&callNode{args: nodes})
+		if len(unknown) > 1 {
+			return "", setUsageError("plot: use 'plot f, x, a, b' to choose the variable")
+		}
+		name = "x"
+		if len(unknown) == 1 {
+			name = unknown[0]
+		}
+	}
+
+	fs := make([]func(float64) float64, len(nodes))
+	for i, node := range nodes {
+		if fs[i], err = realFunction(node, name, a, b); err != nil {
+			return "", err
+		}
+	}
+
+	width, height := screenSize()
+	c := newChart(width, height-len(nodes)-3, a, b)
+	if !c.sample(fs) {
+		return "", setError("plot: no values in [" + fmtFloat(a) + ", " + fmtFloat(b) + "]")
+	}
+	lines := c.render(name)
+	for i, node := range nodes {
+		lines = append(lines, setFgColor(plotColors[i%len(plotColors)], "⣿")+" "+node.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// evaluate range a, b with a < b
+func plotBounds(from, to Node) (float64, float64, error) {
+	va, err := eval(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	vb, err := eval(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	a, b := va.Float(), vb.Float()
+	if !(a < b) || math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return 0, 0, setError("range start must be less than its end")
+	}
+	return a, b, nil
+}
+
+// braille chart, every character holds 2x4 dots
+type chart struct {
+	a, b, ymin, ymax float64
+	// dots of functions and axes, color of function by character
+	dots, axes [][]uint8
+	colors     [][]int
+	// sampled values, one per dot column
+	values [][]float64
+}
+
+func newChart(width, height int, a, b float64) *chart {
+	c := &chart{a: a, b: b}
+	cols := width - plotLabelWidth - 2
+	if cols < minPlotWidth {
+		cols = minPlotWidth
+	}
+	if height < minPlotHeight {
+		height = minPlotHeight
+	}
+	c.dots = make([][]uint8, height)
+	c.axes = make([][]uint8, height)
+	c.colors = make([][]int, height)
+	for i := range c.dots {
+		c.dots[i] = make([]uint8, cols)
+		c.axes[i] = make([]uint8, cols)
+		c.colors[i] = make([]int, cols)
+	}
+	return c
+}
+
+// dots in width and height
+func (c *chart) size() (int, int) {
+	return len(c.dots[0]) * 2, len(c.dots) * 4
+}
+
+// x of dot column
+func (c *chart) x(col int) float64 {
+	w, _ := c.size()
+	return c.a + (c.b-c.a)*float64(col)/float64(w-1)
+}
+
+// dot row of y
+func (c *chart) row(y float64) int {
+	_, h := c.size()
+	return int(math.Round((c.ymax - y) / (c.ymax - c.ymin) * float64(h-1)))
+}
+
+// calculate functions and scale y to their values, false if there is nothing to draw
+func (c *chart) sample(fs []func(float64) float64) bool {
+	w, _ := c.size()
+	c.ymin, c.ymax = math.Inf(1), math.Inf(-1)
+	c.values = make([][]float64, len(fs))
+	for i, f := range fs {
+		c.values[i] = make([]float64, w)
+		for col := range c.values[i] {
+			y := f(c.x(col))
+			c.values[i][col] = y
+			if !math.IsNaN(y) && !math.IsInf(y, 0) {
+				c.ymin = math.Min(c.ymin, y)
+				c.ymax = math.Max(c.ymax, y)
+			}
+		}
+	}
+	if c.ymin > c.ymax {
+		return false
+	}
+	c.clip()
+	if c.ymin == c.ymax {
+		c.ymin, c.ymax = c.ymin-1, c.ymax+1
+	}
+	return true
+}
+
+// drop outliers of scale, e.g. near poles of tan(x), if 5% of values
+// stretch it more than 4 times
+func (c *chart) clip() {
+	var ys []float64
+	for _, values := range c.values {
+		for _, y := range values {
+			if !math.IsNaN(y) && !math.IsInf(y, 0) {
+				ys = append(ys, y)
+			}
+		}
+	}
+	sort.Float64s(ys)
+	lo, hi := ys[len(ys)/20], ys[len(ys)-1-len(ys)/20]
+	if hi > lo && c.ymax-c.ymin > 4*(hi-lo) {
+		margin := (hi - lo) / 2
+		c.ymin = math.Max(c.ymin, lo-margin)
+		c.ymax = math.Min(c.ymax, hi+margin)
+	}
+}
+
+// set dot at column and row of dots
+func setDot(layer [][]uint8, col, row int) {
+	// braille dot bits by column and row inside of a character
+	bits := [2][4]uint8{{0x01, 0x02, 0x04, 0x40}, {0x08, 0x10, 0x20, 0x80}}
+	layer[row/4][col/2] |= bits[col%2][row%4]
+}
+
+// chart lines with y labels on the left and x range below
+func (c *chart) render(name string) []string {
+	w, h := c.size()
+	if c.a <= 0 && c.b >= 0 {
+		col := int(math.Round(-c.a / (c.b - c.a) * float64(w-1)))
+		for row := 0; row < h; row++ {
+			setDot(c.axes, col, row)
+		}
+	}
+	zero := -1
+	if c.ymin <= 0 && c.ymax >= 0 {
+		zero = c.row(0)
+		for col := 0; col < w; col++ {
+			setDot(c.axes, col, zero)
+		}
+	}
+
+	for i, values := range c.values {
+		prev, ok := 0, false
+		for col, y := range values {
+			if math.IsNaN(y) || math.IsInf(y, 0) {
+				ok = false
+				continue
+			}
+			row := c.row(y)
+			// connect with the previous point to keep the curve solid,
+			// except of a jump through the whole chart at a pole
+			from, to := row, row
+			if ok && !(prev < 0 && row >= h || prev >= h && row < 0) {
+				from, to = min(prev, row), max(prev, row)
+			}
+			for r := max(from, 0); r <= min(to, h-1); r++ {
+				setDot(c.dots, col, r)
+				c.colors[r/4][col/2] = i + 1
+			}
+			prev, ok = row, true
+		}
+	}
+
+	labels := map[int]string{0: label(c.ymax), len(c.dots) - 1: label(c.ymin)}
+	if zero >= 0 {
+		labels[zero/4] = "0"
+	}
+	lines := make([]string, 0, len(c.dots)+2)
+	for i := range c.dots {
+		line := strings.Repeat(" ", plotLabelWidth) + " │"
+		if l, ok := labels[i]; ok {
+			line = fmt.Sprintf("%*s ┤", plotLabelWidth, l)
+		}
+		for j, bits := range c.dots[i] {
+			if bits != 0 {
+				color := plotColors[(c.colors[i][j]-1)%len(plotColors)]
+				line += setFgColor(color, string(rune(0x2800+int(bits|c.axes[i][j]))))
+			} else if c.axes[i][j] != 0 {
+				line += string(rune(0x2800 + int(c.axes[i][j])))
+			} else {
+				line += " "
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	cols := len(c.dots[0])
+	indent := strings.Repeat(" ", plotLabelWidth+1)
+	lines = append(lines, indent+"└"+strings.Repeat("─", cols))
+	from, to := label(c.a), label(c.b)
+	gap := cols + 1 - len(from) - len(to) - len(name)
+	if gap < 2 {
+		gap = 2
+	}
+	lines = append(lines, indent+from+strings.Repeat(" ", gap/2)+name+strings.Repeat(" ", gap-gap/2)+to)
+	return lines
+}
+
+// short axis label, precision is reduced to fit big exponents into the
+// label width, e.g. -1.9e+130
+func label(x float64) string {
+	s := strconv.FormatFloat(x, 'g', 4, 64)
+	for prec := 3; len(s) > plotLabelWidth && prec > 0; prec-- {
+		s = strconv.FormatFloat(x, 'g', prec, 64)
+	}
+	return s
+}
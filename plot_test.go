@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var labelTests = []struct {
+	x    float64
+	want string
+}{
+	{0, "0"},
+	{-1, "-1"},
+	{3.14159, "3.142"},
+	{123456, "1.235e+05"},
+	{-4.7213e124, "-4.72e+124"},
+	{-1.9e300, "-1.9e+300"},
+	{-1.7976931348623157e308, "-1.8e+308"},
+}
+
+func TestLabel(t *testing.T) {
+	for _, test := range labelTests {
+		if got := label(test.x); got != test.want {
+			t.Errorf("label(%g) = %q, expected %q", test.x, got, test.want)
+		}
+	}
+}
+
+// extreme ranges used to overflow the label column
+var plotTests = []string{
+	"sin(x)",
+	"-exp(x), x, 0, 300",
+	"exp(x), x, -300, 700",
+	"-x^2, x, -10^150, 10^150",
+	"x^3, x, -10^100, 10^100",
+	"tan(x), x, -pi, pi",
+}
+
+func TestPlot(t *testing.T) {
+	resetVariables()
+	for _, in := range plotTests {
+		out, err := plot(in)
+		if err != nil {
+			t.Errorf("plot %s: unexpected error %v", in, err)
+			continue
+		}
+		lines := strings.Split(out, "\n")
+		for _, line := range lines[:len(lines)-3] {
+			axis := strings.IndexAny(line, "┤│")
+			if axis != plotLabelWidth+1 {
+				t.Errorf("plot %s: y axis at %d, expected %d: %q", in, axis, plotLabelWidth+1, line)
+				break
+			}
+		}
+	}
+}
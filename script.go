@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// relative tolerance of equality of floats in assertions
+const assertTolerance = 1e-9
+
+// comparison operators of assertions, two-character ones are matched first
+var comparisons = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func init() {
+	lineCommands["print"] = lineCommand{printCommand, `print ["format",] x, ...`, "print values, format uses %d, %f, %e, %g, %s verbs"}
+	lineCommands["assert"] = lineCommand{assert, "assert a == b", "fail if comparison (==, !=, <, <=, >, >=) or value is false"}
+}
+
+// print "payment %.2f for %d months", p, n
+func printCommand(rest string) (string, error) {
+	format, rest, err := splitFormat(rest)
+	if err != nil {
+		return "", err
+	}
+	var args []Value
+	if rest != "" {
+		nodes, err := parseList(rest)
+		if err != nil {
+			return "", err
+		}
+		if args, err = evalArgs(nodes); err != nil {
+			return "", err
+		}
+	}
+	if format == "" {
+		texts := make([]string, len(args))
+		for i, arg := range args {
+			texts[i] = arg.String()
+		}
+		return strings.Join(texts, " "), nil
+	}
+	return formatValues(format, args)
+}
+
+// split quoted format from the rest of print arguments
+func splitFormat(rest string) (string, string, error) {
+	if !strings.HasPrefix(rest, `"`) {
+		return "", rest, nil
+	}
+	end := 1
+	for ; end < len(rest) && rest[end] != '"'; end++ {
+		if rest[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(rest) {
+		return "", "", setUsageError("print: closing quote not found")
+	}
+	format, err := strconv.Unquote(rest[:end+1])
+	if err != nil {
+		return "", "", setUsageError("print: invalid format string")
+	}
+	rest = strings.TrimSpace(rest[end+1:])
+	if rest != "" {
+		if rest[0] != ',' {
+			return "", "", setUsageError("print: expected ',' after format")
+		}
+		rest = strings.TrimSpace(rest[1:])
+		if rest == "" {
+			return "", "", setUsageError("print: no params found")
+		}
+	}
+	return format, rest, nil
+}
+
+// printf-like formatting of values, arguments are converted to the verb
+func formatValues(format string, values []Value) (string, error) {
+	var args []interface{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		// skip flags, width and precision
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			return "", setUsageError("print: unfinished verb in format")
+		}
+		verb := format[i]
+		if verb == '%' {
+			continue
+		}
+		if len(args) == len(values) {
+			return "", setUsageError("print: not enough arguments for format")
+		}
+		v := values[len(args)]
+		switch verb {
+		case 'd', 'x', 'X', 'o', 'b':
+			n, ok := v.Int()
+			if !ok {
+				return "", setUsageError("print: %" + string(verb) + " needs an integer, got " + v.String())
+			}
+			args = append(args, n)
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			args = append(args, v.Float())
+		case 's', 'v':
+			args = append(args, v.String())
+		default:
+			return "", setUsageError("print: unsupported verb %" + string(verb))
+		}
+	}
+	if len(args) < len(values) {
+		return "", setUsageError("print: too many arguments for format")
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// assert x == 42
+func assert(rest string) (string, error) {
+	for _, op := range comparisons {
+		i := strings.Index(rest, op)
+		if i < 0 {
+			continue
+		}
+		left, err := evaluate(rest[:i])
+		if err != nil {
+			return "", err
+		}
+		right, err := evaluate(rest[i+len(op):])
+		if err != nil {
+			return "", err
+		}
+		if err := checkNumeric(left, right); err != nil {
+			return "", err
+		}
+		if !compare(left, right, op) {
+			return "", &calcError{assertionError, -1, "assertion failed: " + strings.TrimSpace(rest) + ", left side is " + left.String() + ", right side is " + right.String()}
+		}
+		return "", nil
+	}
+
+	v, err := evaluate(rest)
+	if err != nil {
+		return "", err
+	}
+	if err := checkNumeric(v); err != nil {
+		return "", err
+	}
+	if v.IsInt() && v.i.Sign() == 0 || !v.IsInt() && v.f == 0 {
+		return "", &calcError{assertionError, -1, "assertion failed: " + strings.TrimSpace(rest) + " is 0"}
+	}
+	return "", nil
+}
+
+// symbolic values have no number to compare, e.g. diff(x^2, x) is 2*x
+func checkNumeric(values ...Value) error {
+	for _, v := range values {
+		if v.symbolic {
+			return setUsageError("assert: can't compare expression " + v.String() + " with unknowns")
+		}
+	}
+	return nil
+}
+
+// compare values, floats are equal within assertTolerance
+func compare(a, b Value, op string) bool {
+	var c int
+	if a.IsInt() && b.IsInt() {
+		c = a.i.Cmp(b.i)
+	} else {
+		x, y := a.Float(), b.Float()
+		switch {
+		case math.Abs(x-y) <= assertTolerance*math.Max(1, math.Max(math.Abs(x), math.Abs(y))):
+			c = 0
+		case x < y:
+			c = -1
+		default:
+			c = 1
+		}
+	}
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<=":
+		return c <= 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	}
+	return c > 0
+}
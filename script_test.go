@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var stripCommentTests = []struct {
+	in, out string
+}{
+	{"1 + 1", "1 + 1"},
+	{"1 + 1 # sum", "1 + 1 "},
+	{"# only comment", ""},
+	{`print "#%d", 5 # number`, `print "#%d", 5 `},
+	{`print "a # b"`, `print "a # b"`},
+}
+
+func TestStripComment(t *testing.T) {
+	for _, test := range stripCommentTests {
+		if out := stripComment(test.in); out != test.out {
+			t.Errorf("stripComment(%q) = %q, expected %q", test.in, out, test.out)
+		}
+	}
+}
+
+var scriptTests = []struct {
+	in   string
+	out  string
+	code int
+}{
+	{"# loan\nx = 6 # months\nx * 2\n", "12\n", exitOK},
+	// continuation by \
+	{"1 + \\\n2\n", "3\n", exitOK},
+	{"gcd(12, \\\n  18, \\\n  27)\n", "3\n", exitOK},
+	// unclosed parenthesis fails only its own line, also in quotes
+	{"1+1\nsin(1\n2+2\n3+3\n", "2\n4\n6\n", exitSyntax},
+	{"print \"(%d\", 5\n2+2\n", "(5\n4\n", exitOK},
+	{"2+2\n(1 + \\\n", "4\n", exitSyntax},
+	{`print "%.2f for %d months", 1/3, 12` + "\n", "0.33 for 12 months\n", exitOK},
+	{"print 1, 2, 3\n", "1 2 3\n", exitOK},
+	{"assert 2 + 2 == 4\nassert 0.1 + 0.2 == 0.3\nassert 1 < 2\n5\n", "5\n", exitOK},
+	// failed assertion stops the script with its own exit code
+	{"1 / 0\nassert 1 == 2\n5\n", "", exitAssert},
+	{"assert 0\n", "", exitAssert},
+	// expressions with unknowns can't be compared
+	{"assert diff(x^2, x) == 0\n5\n", "5\n", exitUsage},
+}
+
+func TestScript(t *testing.T) {
+	for _, test := range scriptTests {
+		resetVariables()
+		var code int
+		out, _ := captureOutput(t, func() {
+			code = batch(strings.NewReader(test.in), "stdin")
+		})
+		if out != test.out || code != test.code {
+			t.Errorf("script %q = %q, code %d, expected %q, code %d", test.in, out, code, test.out, test.code)
+		}
+	}
+}
+
+func writeScripts(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"main.icalc":   "include \"consts.icalc\"\nrate * 100\n",
+		"consts.icalc": "# shared constants\nrate = 0.05\n",
+		"loop.icalc":   "include \"loop.icalc\"\n",
+		"broken.icalc": "include \"missing.icalc\"\n7\n",
+	})
+
+	resetVariables()
+	var code int
+	out, _ := captureOutput(t, func() {
+		code = batchFile(filepath.Join(dir, "main.icalc"))
+	})
+	if out != "5\n" || code != exitOK {
+		t.Errorf("include = %q, code %d, expected \"5\\n\", code %d", out, code, exitOK)
+	}
+
+	for _, name := range []string{"loop.icalc", "broken.icalc"} {
+		resetVariables()
+		_, errText := captureOutput(t, func() {
+			code = batchFile(filepath.Join(dir, name))
+		})
+		if code != exitUsage || !strings.Contains(errText, "include: ") {
+			t.Errorf("%s: code %d, error %q, expected include error with code %d", name, code, errText, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// number of subintervals scanned for sign changes
+const solveSteps = 2000
+
+// search range if no bracket hint is given
+const solveRange = 100.0
+
+// max number of reported roots
+const maxRoots = 100
+
+// max iterations of Brent's and Newton's methods
+const rootIterations = 200
+
+var solvePattern = regexp.MustCompile(`^(.+?)(?:\s+for\s+([a-zA-Z_]\w*))?(?:\s+in\s+(.+))?$`)
+
+func init() {
+	lineCommands["solve"] = lineCommand{solve, "solve <equation> [for x] [in a, b]", "numeric roots of an equation, in -100..100 by default"}
+}
+
+// solve x^2 - 4 = 0 for x in -10, 10
+func solve(rest string) (string, error) {
+	m := solvePattern.FindStringSubmatch(rest)
+	if m == nil {
+		return "", setUsageError("solve: no equation found")
+	}
+	node, err := parseEquation(m[1])
+	if err != nil {
+		return "", err
+	}
+
+	name := m[2]
+	if name == "" {
+		unknown := unknownIdentifiers(node)
+		if len(unknown) != 1 {
+			return "", setUsageError("solve: use 'for <variable>' to choose the unknown")
+		}
+		name = unknown[0]
+	}
+
+	a, b := -solveRange, solveRange
+	if m[3] != "" {
+		a, b, err = parseRange(m[3])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	f, err := realFunction(node, name, a, b)
+	if err != nil {
+		return "", err
+	}
+	roots := findRoots(f, a, b)
+	bounds := "[" + fmtFloat(a) + ", " + fmtFloat(b) + "]"
+	if len(roots) == 0 {
+		return "No roots found in " + bounds, nil
+	}
+	if len(roots) > solveSteps/2 {
+		return "Every " + name + " in " + bounds + " is a root", nil
+	}
+
+	lines := make([]string, 0, len(roots))
+	for index, root := range roots {
+		if index == maxRoots {
+			lines = append(lines, "... "+strconv.Itoa(len(roots)-maxRoots)+" more roots")
+			break
+		}
+		lines = append(lines, name+" = "+cleanFloat(root).String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// parse equation lhs = rhs into expression lhs - rhs
+func parseEquation(equation string) (Node, error) {
+	sides := strings.Split(equation, "=")
+	if len(sides) > 2 {
+		return nil, setUsageError("solve: equation must contain one '='")
+	}
+	lhs, err := parse(sides[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(sides) == 1 {
+		return lhs, nil
+	}
+	rhs, err := parse(sides[1])
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: "-", left: lhs, right: rhs}, nil
+}
+
+// parse range a, b with a < b
+func parseRange(params string) (float64, float64, error) {
+	nodes, err := parseList(params)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(nodes) != 2 {
+		return 0, 0, setError("range must be given as <from>, <to>")
+	}
+	from, err := eval(nodes[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := eval(nodes[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	a, b := from.Float(), to.Float()
+	if !(a < b) || math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return 0, 0, setError("range start must be less than its end")
+	}
+	return a, b, nil
+}
+
+// make float function of variable name from expression, points where the
+// expression can't be calculated (e.g. division by zero) are NaN
+func realFunction(n Node, name string, a, b float64) (func(float64) float64, error) {
+	f := func(x float64) float64 {
+		v, err := evalWith(n, name, floatValue(x))
+		if err != nil {
+			return math.NaN()
+		}
+		return v.Float()
+	}
+	// report errors which don't depend on the variable, e.g. unknown identifier
+	var err error
+	for _, x := range []float64{a, (a + b) / 2, b} {
+		if _, err = evalWith(n, name, floatValue(x)); err == nil {
+			return f, nil
+		}
+	}
+	return f, err
+}
+
+// find roots of f in [a, b] by sign changes on a grid refined with Brent's
+// method, roots touching zero without sign change are refined with Newton's
+func findRoots(f func(float64) float64, a, b float64) []float64 {
+	step := (b - a) / solveSteps
+	xs := make([]float64, solveSteps+1)
+	ys := make([]float64, solveSteps+1)
+	for i := range xs {
+		xs[i] = a + float64(i)*step
+		if i == solveSteps {
+			xs[i] = b
+		}
+		ys[i] = f(xs[i])
+	}
+
+	var roots []float64
+	for i := range xs {
+		switch {
+		case ys[i] == 0:
+			roots = append(roots, xs[i])
+		case i > 0 && ys[i-1]*ys[i] < 0:
+			root := brent(f, xs[i-1], xs[i], ys[i-1], ys[i])
+			// sign also changes at poles, e.g. 1/x
+			if math.Abs(f(root)) < 1e-6 {
+				roots = append(roots, root)
+			}
+		case i > 0 && i < solveSteps && ys[i-1]*ys[i] > 0 && ys[i]*ys[i+1] > 0 &&
+			math.Abs(ys[i]) < math.Abs(ys[i-1]) && math.Abs(ys[i]) < math.Abs(ys[i+1]):
+			root, ok := touchingRoot(f, xs[i])
+			if ok && root > xs[i-1] && root < xs[i+1] {
+				roots = append(roots, root)
+			}
+		}
+	}
+
+	// remove duplicates found in neighbouring subintervals
+	sort.Float64s(roots)
+	var result []float64
+	for _, root := range roots {
+		if len(result) > 0 && math.Abs(root-result[len(result)-1]) < step/2 {
+			continue
+		}
+		result = append(result, root)
+	}
+	return result
+}
+
+// Brent's method for root of f in [a, b], f(a) and f(b) must have different signs
+func brent(f func(float64) float64, a, b, fa, fb float64) float64 {
+	c, fc := b, fb
+	var d, e float64
+	for i := 0; i < rootIterations; i++ {
+		if fb > 0 && fc > 0 || fb < 0 && fc < 0 {
+			c, fc = a, fa
+			d = b - a
+			e = d
+		}
+		if math.Abs(fc) < math.Abs(fb) {
+			a, b, c = b, c, b
+			fa, fb, fc = fb, fc, fb
+		}
+		tol := 2*1e-16*math.Abs(b) + 1e-300
+		m := (c - b) / 2
+		if math.Abs(m) <= tol || fb == 0 {
+			return b
+		}
+		if math.Abs(e) >= tol && math.Abs(fa) > math.Abs(fb) {
+			// inverse quadratic interpolation or secant
+			s := fb / fa
+			var p, q float64
+			if a == c {
+				p = 2 * m * s
+				q = 1 - s
+			} else {
+				q = fa / fc
+				r := fb / fc
+				p = s * (2*m*q*(q-r) - (b-a)*(r-1))
+				q = (q - 1) * (r - 1) * (s - 1)
+			}
+			if p > 0 {
+				q = -q
+			} else {
+				p = -p
+			}
+			if 2*p < math.Min(3*m*q-math.Abs(tol*q), math.Abs(e*q)) {
+				e = d
+				d = p / q
+			} else {
+				// bisection
+				d = m
+				e = d
+			}
+		} else {
+			d = m
+			e = d
+		}
+		a, fa = b, fb
+		if math.Abs(d) > tol {
+			b += d
+		} else if m > 0 {
+			b += tol
+		} else {
+			b -= tol
+		}
+		fb = f(b)
+	}
+	return b
+}
+
+// Newton's method for a double root, e.g. (x-1)^2, which converges to x
+// where f touches zero, ok is false if f(root) isn't zero
+func touchingRoot(f func(float64) float64, x float64) (float64, bool) {
+	for i := 0; i < rootIterations; i++ {
+		y := f(x)
+		if y == 0 {
+			break
+		}
+		h := 1e-7 * math.Max(1, math.Abs(x))
+		d := (f(x+h) - f(x-h)) / (2 * h)
+		if d == 0 || math.IsNaN(d) {
+			break
+		}
+		step := 2 * y / d
+		x -= step
+		if math.Abs(step) < 1e-15*math.Max(1, math.Abs(x)) {
+			break
+		}
+	}
+	return x, math.Abs(f(x)) < 1e-12
+}
+
+// round off calculation noise, e.g. 1.9999999999999998 is 2
+func cleanFloat(x float64) Value {
+	if r := math.Round(x); math.Abs(x-r) < 1e-9*math.Max(1, math.Abs(x)) && math.Abs(r) < 1<<53 {
+		return int64Value(int64(r))
+	}
+	x, _ = strconv.ParseFloat(strconv.FormatFloat(x, 'g', 15, 64), 64)
+	return floatValue(x)
+}
+
+func fmtFloat(x float64) string {
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
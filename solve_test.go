@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+var solveTests = []struct {
+	in, out string
+}{
+	{"x^2 - 4 = 0", "x = -2\nx = 2"},
+	{"x^3 - x = 0", "x = -1\nx = 0\nx = 1"},
+	{"exp(x) = 2", "x = 0.693147180559945"},
+	{"x^2 - 2 = 0 in 0, 10", "x = 1.41421356237309"},
+	{"sin(t) = 0 for t in -4, 4", "t = -3.14159265358979\nt = 0\nt = 3.14159265358979"},
+	// touching roots without a sign change
+	{"(x-1)^2 = 0", "x = 1"},
+	{"(x-3)^4 = 0", "x = 3"},
+	{"x*(x-2)^2 = 0", "x = 0\nx = 2"},
+	{"x^2 = -1", "No roots found in [-100, 100]"},
+	{"x = x", "Every x in [-100, 100] is a root"},
+}
+
+func TestSolve(t *testing.T) {
+	resetVariables()
+	for _, test := range solveTests {
+		out, err := solve(test.in)
+		if err != nil {
+			t.Errorf("solve %s: unexpected error %v", test.in, err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("solve %s = %q, expected %q", test.in, out, test.out)
+		}
+	}
+}
+
+var solveErrorTests = []string{
+	"y^2 + x = 0",
+	"x^2 = 2 in 5, 1",
+	"x^2 = ",
+}
+
+func TestSolveErrors(t *testing.T) {
+	resetVariables()
+	for _, in := range solveErrorTests {
+		if _, err := solve(in); err == nil {
+			t.Errorf("solve %s: expected error", in)
+		}
+	}
+}
+
+func TestTouchingRoots(t *testing.T) {
+	tests := []struct {
+		f    func(float64) float64
+		root float64
+	}{
+		{func(x float64) float64 { return (x - 1) * (x - 1) }, 1},
+		{func(x float64) float64 { return -(x + 0.5) * (x + 0.5) }, -0.5},
+		{func(x float64) float64 { return math.Pow(x-math.Pi, 2) }, math.Pi},
+	}
+	for _, test := range tests {
+		roots := findRoots(test.f, -10, 10)
+		if len(roots) != 1 || math.Abs(roots[0]-test.root) > 1e-6 {
+			t.Errorf("roots %v, expected %v", roots, test.root)
+		}
+	}
+}
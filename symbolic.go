@@ -0,0 +1,624 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"sort"
+)
+
+// max passes of simplification until expression stops changing
+const simplifyPasses = 10
+
+func init() {
+	forms["diff"] = form{2, 3, differentiate, "diff(f, x, [at])", "derivative of f by x, at a point if given"}
+	forms["simplify"] = form{1, 1, simplifyForm, "simplify(f)", "fold constants, collect like terms and powers"}
+}
+
+// calculate expression with names bound to themselves as symbols, e.g. x + x*2
+// with unknown x is an expression x + x*2 and not an error
+func evalSymbolic(n Node, names []string) (Value, error) {
+	saved := map[string]Value{}
+	for _, name := range names {
+		if v, ok := variables[name]; ok {
+			saved[name] = v
+		}
+		variables[name] = symbolicValue(&identNode{name: name})
+	}
+	res, err := eval(n)
+	for _, name := range names {
+		if v, ok := saved[name]; ok {
+			variables[name] = v
+		} else {
+			delete(variables, name)
+		}
+	}
+	return res, err
+}
+
+// value of simplified expression, numbers are not symbolic
+func expressionValue(n Node) Value {
+	if num, ok := n.(*numberNode); ok {
+		return num.value
+	}
+	return symbolicValue(n)
+}
+
+func simplifyForm(args []Node) (Value, error) {
+	f, err := evalSymbolic(args[0], unknownIdentifiers(args[0]))
+	if err != nil {
+		return f, err
+	}
+	return expressionValue(simplify(valueNode(f))), nil
+}
+
+func differentiate(args []Node) (Value, error) {
+	x, ok := args[1].(*identNode)
+	if !ok {
+		return Value{}, setError("diff: second argument must be a variable")
+	}
+	names := unknownIdentifiers(args[0])
+	if _, isConstant := constants[x.name]; isConstant {
+		return Value{}, setError("diff: " + x.name + " is a constant")
+	}
+	names = append(names, x.name)
+	f, err := evalSymbolic(args[0], names)
+	if err != nil {
+		return f, err
+	}
+	d, err := derivative(valueNode(f), x.name)
+	if err != nil {
+		return Value{}, err
+	}
+	d = simplify(d)
+	if len(args) == 3 {
+		at, err := eval(args[2])
+		if err != nil {
+			return at, err
+		}
+		return evalWith(d, x.name, at)
+	}
+	return expressionValue(d), nil
+}
+
+// expression constructors
+func symNum(i int64) Node {
+	return &numberNode{value: int64Value(i)}
+}
+
+func symAdd(a, b Node) Node {
+	return &binaryNode{op: "+", left: a, right: b}
+}
+
+func symSub(a, b Node) Node {
+	return &binaryNode{op: "-", left: a, right: b}
+}
+
+func symMul(a, b Node) Node {
+	return &binaryNode{op: "*", left: a, right: b}
+}
+
+func symDiv(a, b Node) Node {
+	return &binaryNode{op: "/", left: a, right: b}
+}
+
+func symPow(a, b Node) Node {
+	return &binaryNode{op: "^", left: a, right: b}
+}
+
+func symNeg(a Node) Node {
+	return &unaryNode{op: "-", x: a}
+}
+
+func symCall(name string, args ...Node) Node {
+	return &callNode{name: name, args: args}
+}
+
+// check if expression depends on x
+func nodeContains(n Node, x string) bool {
+	for _, name := range identifiers(n) {
+		if name == x {
+			return true
+		}
+	}
+	return false
+}
+
+// derivative of expression by x, not simplified
+func derivative(n Node, x string) (Node, error) {
+	if !nodeContains(n, x) {
+		return symNum(0), nil
+	}
+	switch n := n.(type) {
+	case *identNode:
+		return symNum(1), nil
+	case *unaryNode:
+		dx, err := derivative(n.x, x)
+		if err != nil {
+			return nil, err
+		}
+		return symNeg(dx), nil
+	case *binaryNode:
+		du, err := derivative(n.left, x)
+		if err != nil {
+			return nil, err
+		}
+		dv, err := derivative(n.right, x)
+		if err != nil {
+			return nil, err
+		}
+		u, v := n.left, n.right
+		switch n.op {
+		case "+":
+			return symAdd(du, dv), nil
+		case "-":
+			return symSub(du, dv), nil
+		case "*":
+			return symAdd(symMul(du, v), symMul(u, dv)), nil
+		case "/":
+			return symDiv(symSub(symMul(du, v), symMul(u, dv)), symPow(v, symNum(2))), nil
+		case "^":
+			if !nodeContains(v, x) {
+				return symMul(symMul(v, symPow(u, symSub(v, symNum(1)))), du), nil
+			}
+			if !nodeContains(u, x) {
+				return symMul(symMul(n, symCall("ln", u)), dv), nil
+			}
+			return symMul(n, symAdd(symMul(dv, symCall("ln", u)), symDiv(symMul(v, du), u))), nil
+		}
+		return nil, setError("diff: can't differentiate " + n.op)
+	case *callNode:
+		return callDerivative(n, x)
+	}
+	return nil, setError("diff: can't differentiate " + n.String())
+}
+
+// derivative of function call by chain rule
+func callDerivative(n *callNode, x string) (Node, error) {
+	if n.name == "log" && len(n.args) == 2 && !nodeContains(n.args[1], x) {
+		du, err := derivative(n.args[0], x)
+		if err != nil {
+			return nil, err
+		}
+		return symDiv(du, symMul(n.args[0], symCall("ln", n.args[1]))), nil
+	}
+	if len(n.args) != 1 {
+		return nil, setError("diff: can't differentiate " + n.name)
+	}
+	u := n.args[0]
+	du, err := derivative(u, x)
+	if err != nil {
+		return nil, err
+	}
+	var d Node
+	switch n.name {
+	case "sin":
+		d = symCall("cos", u)
+	case "cos":
+		d = symNeg(symCall("sin", u))
+	case "tan":
+		d = symDiv(symNum(1), symPow(symCall("cos", u), symNum(2)))
+	case "asin":
+		d = symDiv(symNum(1), symCall("sqrt", symSub(symNum(1), symPow(u, symNum(2)))))
+	case "acos":
+		d = symNeg(symDiv(symNum(1), symCall("sqrt", symSub(symNum(1), symPow(u, symNum(2))))))
+	case "atan":
+		d = symDiv(symNum(1), symAdd(symNum(1), symPow(u, symNum(2))))
+	case "sinh":
+		d = symCall("cosh", u)
+	case "cosh":
+		d = symCall("sinh", u)
+	case "tanh":
+		d = symDiv(symNum(1), symPow(symCall("cosh", u), symNum(2)))
+	case "exp":
+		d = n
+	case "ln":
+		d = symDiv(symNum(1), u)
+	case "log":
+		d = symDiv(symNum(1), symMul(u, symCall("ln", symNum(10))))
+	case "sqrt":
+		d = symDiv(symNum(1), symMul(symNum(2), n))
+	case "abs":
+		d = symDiv(u, n)
+	default:
+		return nil, setError("diff: can't differentiate " + n.name)
+	}
+	// trigonometric functions in degrees are scaled by pi/180
+	if angleScale != 1 {
+		pi := &identNode{name: "pi"}
+		switch n.name {
+		case "sin", "cos", "tan":
+			d = symMul(symDiv(pi, symNum(180)), d)
+		case "asin", "acos", "atan":
+			d = symMul(symDiv(symNum(180), pi), d)
+		}
+	}
+	return symMul(d, du), nil
+}
+
+// simplify expression: fold constants, collect like terms and powers
+func simplify(n Node) Node {
+	for i := 0; i < simplifyPasses; i++ {
+		s := simplifyNode(n)
+		if s.String() == n.String() {
+			return s
+		}
+		n = s
+	}
+	return n
+}
+
+func simplifyNode(n Node) Node {
+	switch n := n.(type) {
+	case *unaryNode:
+		return simplifySum(&unaryNode{op: n.op, x: simplifyNode(n.x)})
+	case *binaryNode:
+		b := &binaryNode{op: n.op, left: simplifyNode(n.left), right: simplifyNode(n.right), pos: n.pos}
+		switch n.op {
+		case "+", "-":
+			return simplifySum(b)
+		case "*", "/":
+			return simplifyProduct(b)
+		case "^":
+			return simplifyPower(b)
+		}
+		return foldConstants(b)
+	case *callNode:
+		c := &callNode{name: n.name, args: make([]Node, len(n.args)), pos: n.pos}
+		for i, arg := range n.args {
+			c.args[i] = simplifyNode(arg)
+		}
+		return foldCall(c)
+	}
+	return n
+}
+
+// numeric value of expression if it is a number
+func numberOf(n Node) (Value, bool) {
+	if num, ok := n.(*numberNode); ok && !num.value.symbolic {
+		return num.value, true
+	}
+	return Value{}, false
+}
+
+// calculate operator of two numbers
+func foldConstants(n *binaryNode) Node {
+	if _, ok := numberOf(n.left); !ok {
+		return n
+	}
+	if _, ok := numberOf(n.right); !ok {
+		return n
+	}
+	v, err := evalBinary(n)
+	if err != nil {
+		return n
+	}
+	return &numberNode{value: v}
+}
+
+// calculate function of numbers if result is an integer, so that ln(2)
+// stays exact but sqrt(4) is 2
+func foldCall(n *callNode) Node {
+	for _, arg := range n.args {
+		if _, ok := numberOf(arg); !ok {
+			return n
+		}
+	}
+	v, err := evalCall(n)
+	if err != nil || v.symbolic {
+		return n
+	}
+	if i, ok := v.Int(); ok {
+		return &numberNode{value: intValue(i)}
+	}
+	return n
+}
+
+// term of a sum, coef*node
+type term struct {
+	coef Value
+	node Node
+}
+
+// collect like terms: x + 2*x - 1 + 3 is 3*x + 2
+func simplifySum(n Node) Node {
+	var terms []term
+	constant := int64Value(0)
+	collectTerms(n, false, &terms, &constant)
+
+	var result Node
+	for _, t := range terms {
+		if t.coef.Float() == 0 {
+			continue
+		}
+		negative := t.coef.Float() < 0
+		coef := t.coef
+		if negative && result != nil {
+			coef = negateValue(coef)
+		}
+		node := scale(coef, t.node)
+		switch {
+		case result == nil:
+			result = node
+		case negative:
+			result = symSub(result, node)
+		default:
+			result = symAdd(result, node)
+		}
+	}
+	switch {
+	case result == nil:
+		return &numberNode{value: constant}
+	case constant.Float() < 0:
+		return symSub(result, &numberNode{value: negateValue(constant)})
+	case constant.Float() > 0:
+		return symAdd(result, &numberNode{value: constant})
+	}
+	return result
+}
+
+func collectTerms(n Node, negative bool, terms *[]term, constant *Value) {
+	switch t := n.(type) {
+	case *binaryNode:
+		if t.op == "+" || t.op == "-" {
+			collectTerms(t.left, negative, terms, constant)
+			collectTerms(t.right, negative != (t.op == "-"), terms, constant)
+			return
+		}
+	case *unaryNode:
+		collectTerms(t.x, !negative, terms, constant)
+		return
+	}
+	if v, ok := numberOf(n); ok {
+		if negative {
+			v = negateValue(v)
+		}
+		*constant = addValues(*constant, v)
+		return
+	}
+
+	coef, node := splitCoefficient(n)
+	if negative {
+		coef = negateValue(coef)
+	}
+	key := node.String()
+	for i := range *terms {
+		if (*terms)[i].node.String() == key {
+			(*terms)[i].coef = addValues((*terms)[i].coef, coef)
+			return
+		}
+	}
+	*terms = append(*terms, term{coef, node})
+}
+
+// split 3*x into 3 and x
+func splitCoefficient(n Node) (Value, Node) {
+	if b, ok := n.(*binaryNode); ok {
+		switch b.op {
+		case "*":
+			if v, ok := numberOf(b.left); ok {
+				return v, b.right
+			}
+		case "/":
+			if _, ok := numberOf(b.left); !ok {
+				coef, node := splitCoefficient(b.left)
+				return coef, symDiv(node, b.right)
+			}
+		}
+	}
+	return int64Value(1), n
+}
+
+// multiply expression by number
+func scale(coef Value, n Node) Node {
+	switch coef.Float() {
+	case 1:
+		return n
+	case -1:
+		return simplifyProduct(symNeg(n))
+	}
+	return simplifyProduct(symMul(&numberNode{value: coef}, n))
+}
+
+// factor of a product, base^exp
+type power struct {
+	base Node
+	exp  Node
+}
+
+// collect powers and numbers: 2*x*x/4 is x^2/2
+func simplifyProduct(n Node) Node {
+	numerator, denominator := int64Value(1), int64Value(1)
+	var factors []power
+	if !collectFactors(n, false, &numerator, &denominator, &factors) {
+		return n
+	}
+	if numerator.Float() == 0 {
+		return symNum(0)
+	}
+
+	// reduce fraction of integers, floats are divided
+	if numerator.IsInt() && denominator.IsInt() {
+		d := new(big.Int).GCD(nil, nil, new(big.Int).Abs(numerator.i), new(big.Int).Abs(denominator.i))
+		numerator = intValue(new(big.Int).Quo(numerator.i, d))
+		denominator = intValue(new(big.Int).Quo(denominator.i, d))
+		if denominator.i.Sign() < 0 {
+			numerator, denominator = negateValue(numerator), negateValue(denominator)
+		}
+	} else {
+		numerator = floatValue(numerator.Float() / denominator.Float())
+		denominator = int64Value(1)
+	}
+
+	var top, bottom []Node
+	for _, f := range factors {
+		if v, ok := numberOf(f.exp); ok {
+			switch {
+			case v.Float() == 0:
+				continue
+			case v.Float() < 0:
+				bottom = append(bottom, powerNode(f.base, negateValue(v)))
+				continue
+			}
+		}
+		top = append(top, simplifyPower(&binaryNode{op: "^", left: f.base, right: f.exp}))
+	}
+	// variables go before functions and sums: 2*x*exp(x)
+	sort.SliceStable(top, func(i, j int) bool {
+		return isVariablePower(top[i]) && !isVariablePower(top[j])
+	})
+
+	negative := numerator.Float() < 0
+	if negative && len(top) > 0 {
+		numerator = negateValue(numerator)
+	}
+	if numerator.Float() != 1 || len(top) == 0 {
+		top = append([]Node{&numberNode{value: numerator}}, top...)
+	} else if negative {
+		top[0] = symNeg(top[0])
+	}
+	if denominator.Float() != 1 {
+		bottom = append([]Node{&numberNode{value: denominator}}, bottom...)
+	}
+
+	result := product(top)
+	if len(bottom) > 0 {
+		result = symDiv(result, product(bottom))
+	}
+	return result
+}
+
+// check if expression is x or x^n
+func isVariablePower(n Node) bool {
+	if p, ok := n.(*binaryNode); ok && p.op == "^" {
+		n = p.left
+	}
+	_, ok := n.(*identNode)
+	return ok
+}
+
+func product(nodes []Node) Node {
+	result := nodes[0]
+	for _, n := range nodes[1:] {
+		result = symMul(result, n)
+	}
+	return result
+}
+
+func powerNode(base Node, exp Value) Node {
+	if exp.Float() == 1 {
+		return base
+	}
+	return symPow(base, &numberNode{value: exp})
+}
+
+// flatten product into numbers and factors, ok is false on division by zero
+func collectFactors(n Node, inverse bool, numerator, denominator *Value, factors *[]power) bool {
+	switch t := n.(type) {
+	case *binaryNode:
+		switch t.op {
+		case "*":
+			return collectFactors(t.left, inverse, numerator, denominator, factors) &&
+				collectFactors(t.right, inverse, numerator, denominator, factors)
+		case "/":
+			return collectFactors(t.left, inverse, numerator, denominator, factors) &&
+				collectFactors(t.right, !inverse, numerator, denominator, factors)
+		case "^":
+			exp := t.right
+			if inverse {
+				exp = simplifyNode(symNeg(exp))
+			}
+			addFactor(factors, t.left, exp)
+			return true
+		}
+	case *unaryNode:
+		*numerator = negateValue(*numerator)
+		return collectFactors(t.x, inverse, numerator, denominator, factors)
+	}
+	if v, ok := numberOf(n); ok {
+		if inverse {
+			if v.Float() == 0 {
+				return false
+			}
+			*denominator = multiplyValues(*denominator, v)
+		} else {
+			*numerator = multiplyValues(*numerator, v)
+		}
+		return true
+	}
+	exp := symNum(1)
+	if inverse {
+		exp = symNum(-1)
+	}
+	addFactor(factors, n, exp)
+	return true
+}
+
+// add base^exp to factors, exponents of the same base are summed
+func addFactor(factors *[]power, base, exp Node) {
+	key := base.String()
+	for i := range *factors {
+		if (*factors)[i].base.String() == key {
+			(*factors)[i].exp = simplifySum(symAdd((*factors)[i].exp, exp))
+			return
+		}
+	}
+	*factors = append(*factors, power{base, exp})
+}
+
+// x^1 is x, x^0 is 1, (x^2)^3 is x^6
+func simplifyPower(n *binaryNode) Node {
+	base, baseOk := numberOf(n.left)
+	exp, expOk := numberOf(n.right)
+	switch {
+	case expOk && exp.Float() == 0:
+		return symNum(1)
+	case expOk && exp.Float() == 1:
+		return n.left
+	case baseOk && base.Float() == 1:
+		return symNum(1)
+	case baseOk && base.Float() == 0 && expOk && exp.Float() > 0:
+		return symNum(0)
+	case baseOk && expOk:
+		if exp.IsInt() && exp.i.Sign() < 0 {
+			return simplifyProduct(symDiv(symNum(1), &binaryNode{op: "^", left: n.left, right: &numberNode{value: negateValue(exp)}}))
+		}
+		v := powValues(base, exp)
+		if v.IsInt() || !base.IsInt() || math.IsInf(v.Float(), 0) {
+			return &numberNode{value: v}
+		}
+	case expOk:
+		if inner, ok := n.left.(*binaryNode); ok && inner.op == "^" {
+			if innerExp, ok := numberOf(inner.right); ok {
+				product := multiplyValues(innerExp, exp)
+				switch {
+				case exp.IsInt() || isOdd(innerExp) && isOddRoot(exp):
+					return simplifyPower(&binaryNode{op: "^", left: inner.left, right: &numberNode{value: product}})
+				case innerExp.IsInt() && !isOdd(innerExp):
+					// even power is never negative, (x^4)^0.5 is x^2 and
+					// (x^2)^0.5 is |x|
+					if p, ok := product.Int(); ok {
+						base := inner.left
+						if p.Bit(0) == 1 {
+							base = symCall("abs", base)
+						}
+						return simplifyPower(&binaryNode{op: "^", left: base, right: &numberNode{value: intValue(p)}})
+					}
+				}
+			}
+		}
+	}
+	return n
+}
+
+func isOdd(v Value) bool {
+	return v.IsInt() && v.i.Bit(0) == 1
+}
+
+// check if v is 1/n for odd n, e.g. (x^3)^(1/3) is x for negative x too
+func isOddRoot(v Value) bool {
+	if v.Float() == 0 {
+		return false
+	}
+	n := math.Round(1 / v.Float())
+	return 1/n == v.Float() && math.Mod(n, 2) != 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+var symbolicTests = []struct {
+	in, out string
+}{
+	{"diff(x^2, x)", "2*x"},
+	{"diff(x^2, y)", "0"},
+	{"diff(x^2*y, x)", "2*x*y"},
+	{"diff(sin(x)*x, x)", "x*cos(x) + sin(x)"},
+	{"diff(tan(x), x)", "1/cos(x)^2"},
+	{"diff(exp(2*x), x)", "2*exp(2*x)"},
+	{"diff(ln(x), x)", "1/x"},
+	{"diff(1/x, x)", "-1/x^2"},
+	{"diff(x^x, x)", "x^x*(ln(x) + 1)"},
+	{"diff(x^3, x, 2)", "12"},
+	{"simplify(x + x)", "2*x"},
+	{"simplify(2*x + 3*x)", "5*x"},
+	{"simplify(x*x*2)", "2*x^2"},
+	{"simplify(x - x)", "0"},
+	{"simplify(x/x)", "1"},
+	{"simplify(0*x + 1*y)", "y"},
+	{"simplify((x^2)^3)", "x^6"},
+	{"simplify((x^0.5)^2)", "x"},
+	{"simplify((x^3)^(1/3))", "x"},
+	// even powers are never negative
+	{"simplify((x^2)^0.5)", "abs(x)"},
+	{"simplify((x^4)^0.25)", "abs(x)"},
+	{"simplify((x^4)^0.5)", "x^2"},
+	{"simplify((x^2)^1.5)", "abs(x)^3"},
+	// not folded, x^(2/3) would be undefined for negative x
+	{"simplify((x^2)^(1/3))", "x^2^0.3333333333333333"},
+	{"simplify((x^3)^0.5)", "x^3^0.5"},
+}
+
+func TestSymbolic(t *testing.T) {
+	resetVariables()
+	for _, test := range symbolicTests {
+		res, err := evaluate(test.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.in, err)
+			continue
+		}
+		if res.String() != test.out {
+			t.Errorf("%s = %s, expected %s", test.in, res, test.out)
+		}
+	}
+}
+
+var symbolicErrorTests = []string{
+	"diff(x^2, pi)",
+	"diff(x^2, 3)",
+}
+
+func TestSymbolicErrors(t *testing.T) {
+	resetVariables()
+	for _, in := range symbolicErrorTests {
+		if _, err := evaluate(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
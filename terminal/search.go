@@ -0,0 +1,112 @@
+package terminal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// historySearch is the state of reverse incremental search of history.
+type historySearch struct {
+	// query is the text searched for
+	query []rune
+	// idx is the index of the matching history line, len(history) before
+	// the first match
+	idx int
+	// failed is true if no older line matches query
+	failed bool
+	// prompt, line and pos are restored when the search is aborted
+	prompt string
+	line   []rune
+	pos    int
+}
+
+// startSearch starts reverse incremental search from the newest line.
+func (t *Terminal) startSearch() {
+	t.search = &historySearch{
+		idx:    len(t.history),
+		prompt: t.prompt,
+		line:   append([]rune(nil), t.line...),
+		pos:    t.pos,
+	}
+	t.showSearch(t.line, t.pos)
+}
+
+// handleSearchKey processes a key during search. It returns false if the key
+// ends the search and must be processed as usual, e.g. Enter runs the line
+// found.
+func (t *Terminal) handleSearchKey(key int) bool {
+	s := t.search
+	switch {
+	case key == KeyCtrlR:
+		// next older match
+		if len(s.query) > 0 && !s.failed {
+			t.findOlder(s.idx - 1)
+		} else {
+			t.showSearch(t.line, t.pos)
+		}
+	case key == KeyBackspace:
+		if len(s.query) > 0 {
+			s.query = s.query[:len(s.query)-1]
+		}
+		if len(s.query) == 0 {
+			s.idx, s.failed = len(t.history), false
+			t.showSearch(s.line, s.pos)
+		} else {
+			t.findOlder(len(t.history) - 1)
+		}
+	case key == KeyCtrlG:
+		// abort with the line before search
+		t.search = nil
+		t.redraw(s.prompt, s.line, s.pos)
+	case key == KeyEscape:
+		// keep the line found for editing
+		t.endSearch()
+	case isPrintable(key):
+		s.query = append(s.query, rune(key))
+		from := s.idx
+		if from == len(t.history) {
+			from--
+		}
+		t.findOlder(from)
+	default:
+		t.endSearch()
+		return false
+	}
+	return true
+}
+
+// findOlder shows the newest line matching query from history index from
+// down to the oldest one.
+func (t *Terminal) findOlder(from int) {
+	s := t.search
+	for i := from; i >= 0; i-- {
+		line := string(t.history[i])
+		if pos := strings.LastIndex(line, string(s.query)); pos >= 0 {
+			s.idx, s.failed = i, false
+			t.showSearch([]rune(line), utf8.RuneCountInString(line[:pos]))
+			return
+		}
+	}
+	s.failed = true
+	t.showSearch(t.line, t.pos)
+}
+
+// showSearch redraws the search prompt with line.
+func (t *Terminal) showSearch(line []rune, pos int) {
+	prompt := "(reverse-i-search)`" + string(t.search.query) + "': "
+	if t.search.failed {
+		prompt = "(failed " + prompt[1:]
+	}
+	t.redraw(prompt, line, pos)
+}
+
+// endSearch restores the prompt keeping the line found, history navigation
+// continues from it.
+func (t *Terminal) endSearch() {
+	s := t.search
+	t.search = nil
+	if s.idx < len(t.history) {
+		t.historyIdx = s.idx
+	}
+	t.redraw(s.prompt, t.line, t.pos)
+}
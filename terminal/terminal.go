@@ -5,11 +5,15 @@
 package terminal
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 func max(i, j int) int {
@@ -40,6 +44,18 @@ type EscapeCodes struct {
 	// Foreground colors
 	Black, Red, Green, Yellow, Blue, Magenta, Cyan, White []byte
 
+	// Bright foreground colors
+	BrightBlack, BrightRed, BrightGreen, BrightYellow, BrightBlue, BrightMagenta, BrightCyan, BrightWhite []byte
+
+	// Background colors
+	BgBlack, BgRed, BgGreen, BgYellow, BgBlue, BgMagenta, BgCyan, BgWhite []byte
+
+	// Bold text
+	Bold []byte
+
+	// Dim text
+	Dim []byte
+
 	// Reset all attributes
 	Reset []byte
 }
@@ -54,18 +70,88 @@ var vt100EscapeCodes = EscapeCodes{
 	Cyan:    []byte{KeyEscape, '[', '3', '6', 'm'},
 	White:   []byte{KeyEscape, '[', '3', '7', 'm'},
 
+	BrightBlack:   []byte{KeyEscape, '[', '9', '0', 'm'},
+	BrightRed:     []byte{KeyEscape, '[', '9', '1', 'm'},
+	BrightGreen:   []byte{KeyEscape, '[', '9', '2', 'm'},
+	BrightYellow:  []byte{KeyEscape, '[', '9', '3', 'm'},
+	BrightBlue:    []byte{KeyEscape, '[', '9', '4', 'm'},
+	BrightMagenta: []byte{KeyEscape, '[', '9', '5', 'm'},
+	BrightCyan:    []byte{KeyEscape, '[', '9', '6', 'm'},
+	BrightWhite:   []byte{KeyEscape, '[', '9', '7', 'm'},
+
+	BgBlack:   []byte{KeyEscape, '[', '4', '0', 'm'},
+	BgRed:     []byte{KeyEscape, '[', '4', '1', 'm'},
+	BgGreen:   []byte{KeyEscape, '[', '4', '2', 'm'},
+	BgYellow:  []byte{KeyEscape, '[', '4', '3', 'm'},
+	BgBlue:    []byte{KeyEscape, '[', '4', '4', 'm'},
+	BgMagenta: []byte{KeyEscape, '[', '4', '5', 'm'},
+	BgCyan:    []byte{KeyEscape, '[', '4', '6', 'm'},
+	BgWhite:   []byte{KeyEscape, '[', '4', '7', 'm'},
+
+	Bold: []byte{KeyEscape, '[', '1', 'm'},
+	Dim:  []byte{KeyEscape, '[', '2', 'm'},
+
 	Reset: []byte{KeyEscape, '[', '0', 'm'},
 }
 
+// Color256 returns the escape sequence of foreground color n of the
+// 256-color palette, or nil if the terminal doesn't support escape codes.
+func (e *EscapeCodes) Color256(n uint8) []byte {
+	return e.extended(fmt.Sprintf("38;5;%d", n))
+}
+
+// BgColor256 returns the escape sequence of background color n of the
+// 256-color palette.
+func (e *EscapeCodes) BgColor256(n uint8) []byte {
+	return e.extended(fmt.Sprintf("48;5;%d", n))
+}
+
+// RGB returns the escape sequence of a 24-bit foreground color.
+func (e *EscapeCodes) RGB(r, g, b uint8) []byte {
+	return e.extended(fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+}
+
+// BgRGB returns the escape sequence of a 24-bit background color.
+func (e *EscapeCodes) BgRGB(r, g, b uint8) []byte {
+	return e.extended(fmt.Sprintf("48;2;%d;%d;%d", r, g, b))
+}
+
+// extended builds a SGR sequence with params if the terminal supports
+// escape codes at all, i.e. has a Reset sequence.
+func (e *EscapeCodes) extended(params string) []byte {
+	if len(e.Reset) == 0 {
+		return nil
+	}
+	return []byte("\x1b[" + params + "m")
+}
+
+// DefaultEscapeCodes returns the VT100 escape codes used by new terminals,
+// e.g. to style output written without a Terminal.
+func DefaultEscapeCodes() *EscapeCodes {
+	return &vt100EscapeCodes
+}
+
 // Terminal contains the state for running a VT100 terminal that is capable of
 // reading lines of input.
 type Terminal struct {
 	// AutoCompleteCallback, if non-null, is called for each keypress
-	// with the full input line and the current position of the cursor.
+	// with the full input line and the byte offset of the cursor in it.
 	// If it returns a nil newLine, the key press is processed normally.
 	// Otherwise it returns a replacement line and the new cursor position.
 	AutoCompleteCallback func(line []byte, pos, key int) (newLine []byte, newPos int)
 
+	// Highlighter, if non-null, returns the line with escape codes which
+	// color it, e.g. by syntax. cursor is the byte offset of the cursor or
+	// -1 once the line is entered. Without the escape codes the result must be
+	// the line itself. It's called with the terminal locked.
+	Highlighter func(line []byte, cursor int) []byte
+
+	// PreviewCallback, if non-null, is called after each key press with the
+	// line being edited and returns a preview, e.g. its result, shown dimmed
+	// below the line until it's entered, empty for none. It's called with
+	// the terminal locked.
+	PreviewCallback func(line string) string
+
 	// Escape contains a pointer to the escape codes for this terminal.
 	// It's always a valid pointer, although the escape codes themselves
 	// may be empty if the terminal doesn't support them.
@@ -79,7 +165,7 @@ type Terminal struct {
 	prompt string
 
 	// line is the current line being entered.
-	line []byte
+	line []rune
 	// history is a buffer of previously entered lines
 	history [][]byte
 	// results history
@@ -88,6 +174,36 @@ type Terminal struct {
 	historyIdx int
 	// used for resultsHistory handling
 	enterIdx int
+	// historySize is the max number of lines in history, older ones are dropped
+	historySize int
+	// historyAdded is the number of lines entered since SetHistory
+	historyAdded int
+	// search is the state of reverse incremental search by Ctrl-R, nil
+	// when not searching
+	search *historySearch
+
+	// lastKey and prevKey are the key being handled and the one before it,
+	// consecutive kills are joined and only a yank can be rotated
+	lastKey, prevKey int
+	// killRing holds killed texts, the newest last
+	killRing [][]rune
+	// yankIdx is the index in killRing of the text yanked at
+	// line[yankStart:pos]
+	yankIdx, yankStart int
+	// vi is the state of vi editing mode, nil in emacs mode
+	vi *viState
+	// preview is the text shown below the line by PreviewCallback
+	preview string
+
+	// undoStack and redoStack hold the line before changes undone by
+	// Ctrl-_ and redone by Ctrl-Z, typing is true while characters are
+	// typed, they are undone together
+	undoStack, redoStack []lineState
+	typing               bool
+	// undoing is true if the key being handled undid or redid a change
+	undoing bool
+	// keyBindings maps keys to the keys they act as
+	keyBindings map[int]int
 	// pos is the logical position of the cursor in line
 	pos int
 	// echo is true if local echo is enabled
@@ -110,6 +226,9 @@ type Terminal struct {
 	inBuf     [256]byte
 }
 
+// DefaultHistorySize is the number of lines kept in history by default.
+const DefaultHistorySize = 100
+
 // NewTerminal runs a VT100 terminal on the given ReadWriter. If the ReadWriter is
 // a local terminal, that terminal must first have been put into raw mode.
 // prompt is a string that is written at the start of each input line (i.e.
@@ -119,10 +238,11 @@ func NewTerminal(c io.ReadWriter, prompt string) *Terminal {
 		Escape:     	&vt100EscapeCodes,
 		c:          	c,
 		prompt:     	prompt,
-		history:    	make([][]byte, 0, 100),
-		resultsHistory: make([]string, 0, 100),
+		history:    	make([][]byte, 0, DefaultHistorySize),
+		resultsHistory: make([]string, 0, DefaultHistorySize),
 		historyIdx: 	-1,
 		enterIdx:		0,
+		historySize:	DefaultHistorySize,
 		termWidth:  	80,
 		termHeight: 	24,
 		echo:       	true,
@@ -130,12 +250,26 @@ func NewTerminal(c io.ReadWriter, prompt string) *Terminal {
 }
 
 const (
+	KeyCtrlA     = 1
+	KeyCtrlB     = 2
 	KeyCtrlC     = 3
+	KeyCtrlE     = 5
+	KeyCtrlF     = 6
+	KeyCtrlG     = 7
+	KeyCtrlK     = 11
+	KeyCtrlL     = 12
+	KeyCtrlR     = 18
+	KeyCtrlU     = 21
+	KeyCtrlW     = 23
+	KeyCtrlY     = 25
+	KeyCtrlZ     = 26
+	// KeyCtrlUnderscore is sent by Ctrl-_ and Ctrl-/
+	KeyCtrlUnderscore = 31
 	//KeyCtrlD     = 4
 	KeyEnter     = '\r'
 	KeyEscape    = 27
 	KeyBackspace = 127
-	KeyUnknown   = 256 + iota
+	KeyUnknown   = 0xd800 /* UTF-16 surrogate area */ + iota
 	KeyLeft
 	KeyUp
 	KeyRight
@@ -146,8 +280,47 @@ const (
 	KeyAltDelete
 	KeyAltBackspace
 	KeyCtrlDelete
+	KeyHome
+	KeyEnd
+	KeyAltY
 )
 
+// killRingSize is the number of killed texts kept for yanking.
+const killRingSize = 16
+
+// undoLimit is the number of changes of a line that can be undone.
+const undoLimit = 100
+
+// keyNames are the names of keys accepted by KeyByName besides ctrl-a..ctrl-z.
+var keyNames = map[string]int{
+	"enter":         KeyEnter,
+	"escape":        KeyEscape,
+	"backspace":     KeyBackspace,
+	"left":          KeyLeft,
+	"up":            KeyUp,
+	"right":         KeyRight,
+	"down":          KeyDown,
+	"alt-left":      KeyAltLeft,
+	"alt-right":     KeyAltRight,
+	"delete":        KeyDelete,
+	"alt-delete":    KeyAltDelete,
+	"alt-backspace": KeyAltBackspace,
+	"ctrl-delete":   KeyCtrlDelete,
+	"home":          KeyHome,
+	"end":           KeyEnd,
+	"alt-y":         KeyAltY,
+}
+
+// KeyByName returns the key of a name like "up", "alt-left" or "ctrl-p".
+func KeyByName(name string) (int, bool) {
+	name = strings.ToLower(name)
+	if len(name) == 6 && strings.HasPrefix(name, "ctrl-") && name[5] >= 'a' && name[5] <= 'z' {
+		return int(name[5]-'a') + 1, true
+	}
+	key, ok := keyNames[name]
+	return key, ok
+}
+
 // bytesToKey tries to parse a key sequence from b. If successful, it returns
 // the key and the remainder of the input. Otherwise it returns -1.
 func bytesToKey(b []byte) (int, []byte) {
@@ -156,7 +329,15 @@ func bytesToKey(b []byte) (int, []byte) {
 	}
 
 	if b[0] != KeyEscape {
-		return int(b[0]), b[1:]
+		if b[0] < utf8.RuneSelf {
+			return int(b[0]), b[1:]
+		}
+		// the rest of a multi-byte character may come with the next read
+		if !utf8.FullRune(b) {
+			return -1, b
+		}
+		r, size := utf8.DecodeRune(b)
+		return int(r), b[size:]
 	}
 
 	if len(b) >= 6 && b[0] == KeyEscape && b[1] == '[' {
@@ -184,19 +365,60 @@ func bytesToKey(b []byte) (int, []byte) {
 			return KeyRight, b[3:]
 		case 'D':
 			return KeyLeft, b[3:]
+		case 'H':
+			return KeyHome, b[3:]
+		case 'F':
+			return KeyEnd, b[3:]
 		case '3':
 			if len(b) >= 4 {
 				if b[3] == '~' {
 					return KeyDelete, b[4:]
 				}
 			}
+		case '1', '7':
+			if len(b) >= 4 && b[3] == '~' {
+				return KeyHome, b[4:]
+			}
+		case '4', '8':
+			if len(b) >= 4 && b[3] == '~' {
+				return KeyEnd, b[4:]
+			}
 		}
 	}
 
+	// ESC O sequences are three bytes long
+	if len(b) >= 2 && b[0] == KeyEscape && b[1] == 'O' {
+		if len(b) == 2 {
+			return -1, b
+		}
+		switch b[2] {
+		case 'H':
+			return KeyHome, b[3:]
+		case 'F':
+			return KeyEnd, b[3:]
+		}
+		return KeyUnknown, b[3:]
+	}
+
 	if len(b) >= 2 {
 		if b[0] == KeyEscape && b[1] == KeyBackspace {
 			return KeyAltBackspace, b[2:]
 		}
+		if b[0] == KeyEscape {
+			switch b[1] {
+			case 'b':
+				return KeyAltLeft, b[2:]
+			case 'f':
+				return KeyAltRight, b[2:]
+			case 'y':
+				return KeyAltY, b[2:]
+			}
+		}
+		// Esc pressed alone is known by the key after it, which can't
+		// continue an escape sequence.
+		if b[0] == KeyEscape && b[1] != '[' && b[1] != 'O' {
+			return KeyEscape, b[1:]
+		}
 	}
 
 	// If we get here then we have a key that we don't recognise, or a
@@ -217,11 +439,12 @@ func (t *Terminal) queue(data []byte) {
 	t.outBuf = append(t.outBuf, data...)
 }
 
-var eraseUnderCursor = []byte{' ', KeyEscape, '[', 'D'}
-var space = []byte{' '}
+var space = []rune{' '}
 
+// isPrintable returns true for keys of printable characters, special keys
+// are in the surrogate area which has no characters.
 func isPrintable(key int) bool {
-	return key >= 32 && key < 127
+	return key >= 32 && unicode.IsGraphic(rune(key))
 }
 
 // moveCursorToPos appends data to t.outBuf which will move the cursor to the
@@ -231,7 +454,7 @@ func (t *Terminal) moveCursorToPos(pos int) {
 		return
 	}
 
-	x := len(t.prompt) + pos
+	x := visualLength([]rune(t.prompt)) + visualLength(t.line[:pos])
 	y := x / t.termWidth
 	x = x % t.termWidth
 
@@ -296,12 +519,270 @@ func (t *Terminal) clearLineToRight() {
 	t.queue(op)
 }
 
+// lineState is a line with the cursor position kept for undo.
+type lineState struct {
+	line []rune
+	pos  int
+}
+
+// recordUndo keeps the line before key changed it, characters typed one
+// after another are a single change.
+func (t *Terminal) recordUndo(before lineState, key int) {
+	switch key {
+	case KeyEnter, KeyCtrlC:
+		// a new line has its own changes
+		t.undoStack, t.redoStack, t.typing = nil, nil, false
+		return
+	}
+	if t.undoing {
+		t.undoing, t.typing = false, false
+		return
+	}
+	changed := string(before.line) != string(t.line)
+	typing := changed && isPrintable(key) && len(t.line) == len(before.line)+1
+	if changed && !(typing && t.typing) {
+		t.undoStack = append(t.undoStack, before)
+		if len(t.undoStack) > undoLimit {
+			t.undoStack = t.undoStack[1:]
+		}
+		t.redoStack = nil
+	}
+	t.typing = typing
+}
+
+// undo restores the line before the last change.
+func (t *Terminal) undo() {
+	if len(t.undoStack) == 0 {
+		return
+	}
+	s := t.undoStack[len(t.undoStack)-1]
+	t.undoStack = t.undoStack[:len(t.undoStack)-1]
+	t.redoStack = append(t.redoStack, lineState{append([]rune(nil), t.line...), t.pos})
+	t.undoing = true
+	t.redraw(t.prompt, s.line, s.pos)
+}
+
+// redo restores the line before the last undo.
+func (t *Terminal) redo() {
+	if len(t.redoStack) == 0 {
+		return
+	}
+	s := t.redoStack[len(t.redoStack)-1]
+	t.redoStack = t.redoStack[:len(t.redoStack)-1]
+	t.undoStack = append(t.undoStack, lineState{append([]rune(nil), t.line...), t.pos})
+	t.undoing = true
+	t.redraw(t.prompt, s.line, s.pos)
+}
+
+// kill removes line[from:to] and keeps it in the kill ring, text of
+// consecutive kills is joined.
+func (t *Terminal) kill(from, to int) {
+	if from == to {
+		return
+	}
+	text := append([]rune(nil), t.line[from:to]...)
+	last := len(t.killRing) - 1
+	switch {
+	case last >= 0 && isKillKey(t.prevKey) && from < t.pos:
+		t.killRing[last] = append(text, t.killRing[last]...)
+	case last >= 0 && isKillKey(t.prevKey):
+		t.killRing[last] = append(t.killRing[last], text...)
+	default:
+		t.killRing = append(t.killRing, text)
+		if len(t.killRing) > killRingSize {
+			t.killRing = t.killRing[1:]
+		}
+	}
+	t.deleteRange(from, to)
+}
+
+func isKillKey(key int) bool {
+	switch key {
+	case KeyCtrlK, KeyCtrlU, KeyCtrlW, KeyAltBackspace, KeyAltDelete, KeyCtrlDelete:
+		return true
+	}
+	return false
+}
+
+// deleteRange removes line[from:to] and moves the cursor to from.
+func (t *Terminal) deleteRange(from, to int) {
+	t.pos = from
+	t.moveCursorToPos(t.pos)
+	t.line = append(t.line[:from], t.line[to:]...)
+	t.clearLineToRight()
+	if t.echo {
+		t.writeLine(t.line[t.pos:])
+	}
+	t.moveCursorToPos(t.pos)
+}
+
+// insert puts text at the cursor and moves the cursor after it.
+func (t *Terminal) insert(text []rune) {
+	if len(t.line)+len(text) > maxLineLength {
+		return
+	}
+	line := make([]rune, 0, len(t.line)+len(text))
+	line = append(append(append(line, t.line[:t.pos]...), text...), t.line[t.pos:]...)
+	t.line = line
+	if t.echo {
+		t.writeLine(t.line[t.pos:])
+	}
+	t.pos += len(text)
+	t.moveCursorToPos(t.pos)
+}
+
+// redraw writes prompt and line in place of the ones on the screen, e.g.
+// while the prompt shows a history search, and moves the cursor to pos.
+func (t *Terminal) redraw(prompt string, line []rune, pos int) {
+	t.move(t.cursorY, 0, 0, 0)
+	t.cursorX, t.cursorY = 0, 0
+	// clear the rest of the screen, the line may have wrapped
+	t.queue([]byte{'\r', KeyEscape, '[', 'J'})
+	t.preview = ""
+	t.prompt = prompt
+	t.writeLine([]rune(prompt))
+	t.writeLine(t.render(line, pos))
+	t.line, t.pos = line, pos
+	t.moveCursorToPos(pos)
+}
+
+// render returns line as written to the screen, colored by the Highlighter
+// unless history is being searched.
+func (t *Terminal) render(line []rune, cursor int) []rune {
+	if t.Highlighter == nil || t.search != nil {
+		return line
+	}
+	return []rune(string(t.Highlighter([]byte(string(line)), byteOffset(line, cursor))))
+}
+
+// byteOffset returns the offset in UTF-8 of line of rune position pos, -1
+// stays -1.
+func byteOffset(line []rune, pos int) int {
+	if pos < 0 {
+		return pos
+	}
+	return len(string(line[:pos]))
+}
+
+// highlight writes the line again colored by the Highlighter, cursor is the
+// position passed to it.
+func (t *Terminal) highlight(cursor int) {
+	if !t.echo || t.Highlighter == nil || t.search != nil {
+		return
+	}
+	t.moveCursorToPos(0)
+	t.writeLine(t.render(t.line, cursor))
+	t.moveCursorToPos(t.pos)
+}
+
+// showPreview writes the preview of the line below it if it changed.
+func (t *Terminal) showPreview() {
+	if !t.echo || t.PreviewCallback == nil {
+		return
+	}
+	preview := ""
+	if t.search == nil {
+		preview = t.PreviewCallback(string(t.line))
+	}
+	if preview == t.preview {
+		return
+	}
+	t.preview = preview
+	t.moveCursorToPos(len(t.line))
+	row := t.cursorY
+	t.queue([]byte("\r\n"))
+	t.clearLineToRight()
+	if preview != "" {
+		text := []rune(preview)
+		for visualLength(text) >= t.termWidth {
+			text = text[:len(text)-1]
+		}
+		t.queue(t.Escape.Dim)
+		t.queue([]byte(string(text)))
+		t.queue(t.Escape.Reset)
+	}
+	t.queue([]byte{'\r'})
+	t.move(1, 0, 0, 0)
+	t.cursorX, t.cursorY = 0, row
+	t.moveCursorToPos(t.pos)
+}
+
+// clearPreview removes the preview below the line.
+func (t *Terminal) clearPreview() {
+	if t.preview == "" {
+		return
+	}
+	t.preview = ""
+	t.moveCursorToPos(len(t.line))
+	t.queue([]byte{KeyEscape, '[', 'J'})
+}
+
 const maxLineLength = 512
 
 // handleKey processes the given key and, optionally, returns a line of text
 // that the user has entered.
 func (t *Terminal) handleKey(key int) (line string, ok bool) {
+	t.prevKey, t.lastKey = t.lastKey, key
+	if t.search != nil && t.handleSearchKey(key) {
+		return
+	}
+	if t.vi != nil && t.handleViKey(key) {
+		return
+	}
 	switch key {
+	case KeyCtrlA, KeyHome:
+		t.pos = 0
+		t.moveCursorToPos(t.pos)
+	case KeyCtrlE, KeyEnd:
+		t.pos = len(t.line)
+		t.moveCursorToPos(t.pos)
+	case KeyCtrlB:
+		return t.handleKey(KeyLeft)
+	case KeyCtrlF:
+		return t.handleKey(KeyRight)
+	case KeyCtrlK:
+		t.kill(t.pos, len(t.line))
+	case KeyCtrlU:
+		t.kill(0, t.pos)
+	case KeyCtrlW:
+		// kill the word before the cursor and spaces after it
+		start := t.pos
+		for start > 0 && t.line[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && t.line[start-1] != ' ' {
+			start--
+		}
+		t.kill(start, t.pos)
+	case KeyCtrlY:
+		if len(t.killRing) == 0 {
+			return
+		}
+		t.yankIdx = len(t.killRing) - 1
+		t.yankStart = t.pos
+		t.insert(t.killRing[t.yankIdx])
+	case KeyAltY:
+		// replace the text just yanked with an older one
+		if len(t.killRing) == 0 || t.prevKey != KeyCtrlY && t.prevKey != KeyAltY {
+			return
+		}
+		t.yankIdx = (t.yankIdx + len(t.killRing) - 1) % len(t.killRing)
+		t.deleteRange(t.yankStart, t.pos)
+		t.insert(t.killRing[t.yankIdx])
+		// the key stays a yank for the next Alt-Y
+		t.lastKey = KeyAltY
+	case KeyCtrlUnderscore:
+		t.undo()
+	case KeyCtrlZ:
+		t.redo()
+	case KeyCtrlL:
+		t.queue([]byte{KeyEscape, '[', 'H', KeyEscape, '[', '2', 'J'})
+		t.cursorX, t.cursorY = 0, 0
+		t.redraw(t.prompt, t.line, t.pos)
+	case KeyCtrlR:
+		if t.echo {
+			t.startSearch()
+		}
 	case KeyBackspace:
 		if t.pos == 0 {
 			return
@@ -309,28 +790,18 @@ func (t *Terminal) handleKey(key int) (line string, ok bool) {
 		t.pos--
 		t.moveCursorToPos(t.pos)
 
+		// erase the columns of the character at the end
+		width := runeWidth(t.line[t.pos])
 		copy(t.line[t.pos:], t.line[1+t.pos:])
 		t.line = t.line[:len(t.line)-1]
 		if t.echo {
 			t.writeLine(t.line[t.pos:])
 		}
-		t.queue(eraseUnderCursor)
+		t.queue(bytes.Repeat([]byte{' '}, width))
+		t.move(0, 0, width, 0)
 		t.moveCursorToPos(t.pos)
 	case KeyAltBackspace:
-		if t.pos == 0 {
-			return
-		}
-		currPos := t.pos
-		t.pos = 0
-		t.moveCursorToPos(t.pos)
-
-		copy(t.line[t.pos:], t.line[currPos:])
-		t.line = t.line[:len(t.line)-currPos]
-		t.clearLineToRight()
-		if t.echo {
-			t.writeLine(t.line[t.pos:])
-		}
-		t.moveCursorToPos(t.pos)
+		t.kill(0, t.pos)
 	case KeyDelete:
 		if t.pos == len(t.line) {
 			return
@@ -343,12 +814,7 @@ func (t *Terminal) handleKey(key int) (line string, ok bool) {
 		}
 		t.moveCursorToPos(t.pos)
 	case KeyAltDelete, KeyCtrlDelete:
-		if t.pos == len(t.line) {
-			return
-		}
-		t.line = t.line[:t.pos]
-		t.clearLineToRight()
-		t.moveCursorToPos(t.pos)
+		t.kill(t.pos, len(t.line))
 	case KeyAltLeft:
 		// move left by a word.
 		if t.pos == 0 {
@@ -403,20 +869,18 @@ func (t *Terminal) handleKey(key int) (line string, ok bool) {
 		t.historyIdx--
 		t.historyIdx = historyIdxValue(t.historyIdx, t.history)
 
-		h := t.history[t.historyIdx]
-		newLine := make([]byte, len(h))
-		copy(newLine, h)
+		newLine := []rune(string(t.history[t.historyIdx]))
 		newPos := len(newLine)
 		if t.echo {
 			t.moveCursorToPos(0)
 			t.writeLine(newLine)
-			for i := len(newLine); i < len(t.line); i++ {
+			for i := visualLength(newLine); i < visualLength(t.line); i++ {
 				t.writeLine(space)
 			}
-			t.moveCursorToPos(newPos)
 		}
 		t.line = newLine
 		t.pos = newPos
+		t.moveCursorToPos(newPos)
 		return
 
 	case KeyDown:
@@ -424,30 +888,31 @@ func (t *Terminal) handleKey(key int) (line string, ok bool) {
 			return
 		}
 		newPos := 0
-		newLine := []byte{}
+		newLine := []rune{}
 		t.historyIdx++
 		if t.historyIdx >= len(t.history) {
 			t.historyIdx = len(t.history)
 		} else {
 			t.historyIdx = historyIdxValue(t.historyIdx, t.history)
-			h := t.history[t.historyIdx]
-			newLine = make([]byte, len(h))
-			copy(newLine, h)
+			newLine = []rune(string(t.history[t.historyIdx]))
 			newPos = len(newLine)
 		}
 		if t.echo {
 			t.moveCursorToPos(0)
 			t.writeLine(newLine)
-			for i := len(newLine); i < len(t.line); i++ {
+			for i := visualLength(newLine); i < visualLength(t.line); i++ {
 				t.writeLine(space)
 			}
-			t.moveCursorToPos(newPos)
 		}
 		t.line = newLine
 		t.pos = newPos
+		t.moveCursorToPos(newPos)
 		return
 
 	case KeyEnter:
+		// the entered line keeps colors without marks of the cursor
+		t.highlight(-1)
+		t.clearPreview()
 		t.moveCursorToPos(len(t.line))
 		t.queue([]byte("\r\n"))
 		line = string(t.line)
@@ -473,59 +938,48 @@ func (t *Terminal) handleKey(key int) (line string, ok bool) {
 	default:
 		if t.AutoCompleteCallback != nil {
 			t.lock.Unlock()
-			newLine, newPos := t.AutoCompleteCallback(t.line, t.pos, key)
+			text, offset := t.AutoCompleteCallback([]byte(string(t.line)), byteOffset(t.line, t.pos), key)
 			t.lock.Lock()
 
-			if newLine != nil {
+			if text != nil {
+				newLine := []rune(string(text))
+				newPos := utf8.RuneCount(text[:offset])
 				if t.echo {
 					t.moveCursorToPos(0)
 					t.writeLine(newLine)
-					for i := len(newLine); i < len(t.line); i++ {
+					for i := visualLength(newLine); i < visualLength(t.line); i++ {
 						t.writeLine(space)
 					}
-					t.moveCursorToPos(newPos)
 				}
 				t.line = newLine
 				t.pos = newPos
+				t.moveCursorToPos(newPos)
 				return
 			}
 		}
 		if !isPrintable(key) {
 			return
 		}
-		if len(t.line) == maxLineLength {
-			return
-		}
-		if len(t.line) == cap(t.line) {
-			newLine := make([]byte, len(t.line), 2*(1+len(t.line)))
-			copy(newLine, t.line)
-			t.line = newLine
-		}
-		t.line = t.line[:len(t.line)+1]
-		copy(t.line[t.pos+1:], t.line[t.pos:])
-		t.line[t.pos] = byte(key)
-		if t.echo {
-			t.writeLine(t.line[t.pos:])
-		}
-		t.pos++
-		t.moveCursorToPos(t.pos)
+		t.insert([]rune{rune(key)})
 	}
 	return
 }
 
-func (t *Terminal) writeLine(line []byte) {
+// writeLine writes line at the cursor, escape codes in it take no space on
+// the screen.
+func (t *Terminal) writeLine(line []rune) {
 	for len(line) != 0 {
-		remainingOnLine := t.termWidth - t.cursorX
-		todo := len(line)
-		if todo > remainingOnLine {
-			todo = remainingOnLine
+		if n := escapeLength(line); n > 0 {
+			t.queue([]byte(string(line[:n])))
+			line = line[n:]
+			continue
 		}
-		t.queue(line[:todo])
-		t.cursorX += todo
-		line = line[todo:]
+		t.queue([]byte(string(line[0])))
+		t.cursorX += runeWidth(line[0])
+		line = line[1:]
 
-		if t.cursorX == t.termWidth {
-			t.cursorX = 0
+		if t.cursorX >= t.termWidth {
+			t.cursorX -= t.termWidth
 			t.cursorY++
 			if t.cursorY > t.maxLine {
 				t.maxLine = t.cursorY
@@ -534,6 +988,37 @@ func (t *Terminal) writeLine(line []byte) {
 	}
 }
 
+// escapeLength returns the length of the escape code at the start of b, 0 if
+// there is none.
+func escapeLength(b []rune) int {
+	if len(b) == 0 || b[0] != KeyEscape {
+		return 0
+	}
+	if len(b) < 2 || b[1] != '[' {
+		return min(len(b), 2)
+	}
+	// parameters of CSI codes end with a byte in @..~
+	i := 2
+	for i < len(b) && (b[i] < '@' || b[i] > '~') {
+		i++
+	}
+	return min(i+1, len(b))
+}
+
+// visualLength returns the number of columns text takes on the screen.
+func visualLength(text []rune) int {
+	width := 0
+	for len(text) > 0 {
+		if n := escapeLength(text); n > 0 {
+			text = text[n:]
+			continue
+		}
+		width += runeWidth(text[0])
+		text = text[1:]
+	}
+	return width
+}
+
 func (t *Terminal) Write(buf []byte) (n int, err error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -546,6 +1031,7 @@ func (t *Terminal) Write(buf []byte) (n int, err error) {
 
 	// We have a prompt and possibly user input on the screen. We
 	// have to clear it first.
+	t.clearPreview()
 	t.move(0 /* up */, 0 /* down */, t.cursorX /* left */, 0 /* right */)
 	t.cursorX = 0
 	t.clearLineToRight()
@@ -566,14 +1052,15 @@ func (t *Terminal) Write(buf []byte) (n int, err error) {
 	}
 
 	t.queue([]byte(t.prompt))
-	chars := len(t.prompt)
+	chars := visualLength([]rune(t.prompt))
 	if t.echo {
-		t.queue(t.line)
-		chars += len(t.line)
+		t.queue([]byte(string(t.render(t.line, t.pos))))
+		chars += visualLength(t.line)
 	}
 	t.cursorX = chars % t.termWidth
 	t.cursorY = chars / t.termWidth
 	t.moveCursorToPos(t.pos)
+	t.showPreview()
 
 	if _, err = t.c.Write(t.outBuf); err != nil {
 		return
@@ -612,7 +1099,7 @@ func (t *Terminal) readLine() (line string, err error) {
 	// t.lock must be held at this point
 
 	if t.cursorX == 0 && t.cursorY == 0 {
-		t.writeLine([]byte(t.prompt))
+		t.writeLine([]rune(t.prompt))
 		t.c.Write(t.outBuf)
 		t.outBuf = t.outBuf[:0]
 	}
@@ -626,8 +1113,19 @@ func (t *Terminal) readLine() (line string, err error) {
 			if key < 0 {
 				break
 			}
+			if bound, ok := t.keyBindings[key]; ok {
+				key = bound
+			}
 
+			before := lineState{append([]rune(nil), t.line...), t.pos}
 			line, lineOk = t.handleKey(key)
+			t.recordUndo(before, key)
+			if !lineOk && (t.pos != before.pos || string(t.line) != string(before.line)) {
+				t.highlight(t.pos)
+			}
+			if !lineOk {
+				t.showPreview()
+			}
 			if key == KeyCtrlC {
 				t.remainder = nil
 				return "'q' to close icalc", fmt.Errorf("control-c break")
@@ -649,6 +1147,8 @@ func (t *Terminal) readLine() (line string, err error) {
 				h := make([]byte, len(b))
 				copy(h, b)
 				t.history = append(t.history, h)
+				t.historyAdded++
+				t.trimHistory()
 			}
 			return
 		}
@@ -668,7 +1168,6 @@ func (t *Terminal) readLine() (line string, err error) {
 
 		t.remainder = t.inBuf[:n+len(t.remainder)]
 	}
-	panic("unreachable")
 }
 
 // SetPrompt sets the prompt to be used when reading subsequent lines.
@@ -679,25 +1178,145 @@ func (t *Terminal) SetPrompt(prompt string) {
 	t.prompt = prompt
 }
 
+// ShowCompletions lists candidates in columns below the line being edited,
+// then draws the prompt and the line again under them. It's meant to be
+// called from AutoCompleteCallback when a completion is ambiguous.
+func (t *Terminal) ShowCompletions(candidates []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.echo || len(candidates) == 0 {
+		return
+	}
+	width := 0
+	for _, c := range candidates {
+		width = max(width, visualLength([]rune(c))+2)
+	}
+	cols := max(t.termWidth/width, 1)
+	rows := (len(candidates) + cols - 1) / cols
+
+	t.clearPreview()
+	t.moveCursorToPos(len(t.line))
+	t.queue([]byte("\r\n"))
+	// candidates go down the columns like in shells
+	for row := 0; row < rows; row++ {
+		var text []byte
+		for i := row; i < len(candidates); i += rows {
+			text = append(text, candidates[i]...)
+			if i+rows < len(candidates) {
+				text = append(text, bytes.Repeat([]byte{' '}, width-visualLength([]rune(candidates[i])))...)
+			}
+		}
+		t.queue(append(text, '\r', '\n'))
+	}
+	t.cursorX, t.cursorY = 0, 0
+	t.redraw(t.prompt, t.line, t.pos)
+}
+
+// SetSize sets the size of the terminal, e.g. when the window is resized. A
+// line being edited is drawn again at the new width.
 func (t *Terminal) SetSize(width, height int) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	oldWidth := t.termWidth
 	t.termWidth, t.termHeight = width, height
+	if width == oldWidth || !t.echo || t.cursorX == 0 && t.cursorY == 0 {
+		return
+	}
+	// terminals reflow the line to the new width, the cursor stays at its
+	// position in the line
+	x := visualLength([]rune(t.prompt)) + visualLength(t.line[:t.pos])
+	t.cursorX, t.cursorY = x%width, x/width
+	t.redraw(t.prompt, t.line, t.pos)
+	t.showPreview()
+	t.c.Write(t.outBuf)
+	t.outBuf = t.outBuf[:0]
 }
 
-func (t *Terminal) SetHistory(h []string) {
-	// t.history = make([][]byte, len(h))
-	// for i := range h {
-	// 	t.history[i] = []byte(h[i])
-	// }
-	// //	t.historyIdx = len(h)
+// Size returns the width and height of the terminal set by SetSize.
+func (t *Terminal) Size() (width, height int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.termWidth, t.termHeight
 }
 
-func (t *Terminal) AddResultHistory(h float64) {
+// SetHistorySize sets the max number of lines kept in history, older lines
+// are dropped.
+func (t *Terminal) SetHistorySize(size int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.historySize = size
+	t.trimHistory()
+}
+
+// trimHistory drops the oldest lines over historySize with their results,
+// indexes are moved to keep pointing at the same lines.
+func (t *Terminal) trimHistory() {
+	n := len(t.history) - t.historySize
+	if n <= 0 {
+		return
+	}
+	t.history = t.history[n:]
+	t.resultsHistory = t.resultsHistory[min(n, len(t.resultsHistory)):]
+	t.historyIdx -= n
+	t.enterIdx -= n
+	t.historyAdded = min(t.historyAdded, len(t.history))
+}
+
+// BindKey makes key act as another key, e.g. KeyCtrlP as KeyUp.
+func (t *Terminal) BindKey(key, as int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.keyBindings == nil {
+		t.keyBindings = make(map[int]int)
+	}
+	t.keyBindings[key] = as
+}
+
+// SetHistory replaces history with lines and their results, e.g. loaded
+// from a file. results may be shorter than lines, missing ones are empty.
+func (t *Terminal) SetHistory(lines, results []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.history = make([][]byte, len(lines))
+	t.resultsHistory = make([]string, len(lines))
+	for i := range lines {
+		t.history[i] = []byte(lines[i])
+		if i < len(results) {
+			t.resultsHistory[i] = results[i]
+		}
+	}
+	t.historyIdx = len(lines)
+	t.enterIdx = len(lines)
+	t.historyAdded = 0
+	t.trimHistory()
+}
+
+// NewHistory returns the lines entered since SetHistory and their results.
+func (t *Terminal) NewHistory() (lines, results []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	start := len(t.history) - t.historyAdded
+	for i := start; i < len(t.history); i++ {
+		lines = append(lines, string(t.history[i]))
+		result := ""
+		if i < len(t.resultsHistory) {
+			result = t.resultsHistory[i]
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+func (t *Terminal) AddResultHistory(h string) {
 	if t.enterIdx == t.historyIdx {
-		s := fmt.Sprint(h)
-		t.resultsHistory = append(t.resultsHistory, s)
+		t.resultsHistory = append(t.resultsHistory, h)
 	} else {
 		t.enterIdx = t.historyIdx
 	}
@@ -706,7 +1325,10 @@ func (t *Terminal) AddResultHistory(h float64) {
 func (t *Terminal) GetHistory() (h []string) {
 	h = make([]string, len(t.history))
 	for i := range t.history {
-		h[i] = string(t.history[i]) + " = " + t.resultsHistory[i]
+		h[i] = string(t.history[i])
+		if t.resultsHistory[i] != "" {
+			h[i] += " = " + t.resultsHistory[i]
+		}
 	}
 	return
 }
@@ -735,9 +1357,12 @@ func NewWithStdInOut() (term *Terminal, err error) {
 	fd := int(os.Stdin.Fd())
 	oldState, err = MakeRaw(fd)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	sh := &shell{r: os.Stdin, w: os.Stdout}
 	term = NewTerminal(sh, "")
+	if width, height, err := GetSize(int(os.Stdout.Fd())); err == nil && width > 0 && height > 0 {
+		term.SetSize(width, height)
+	}
 	return
 }
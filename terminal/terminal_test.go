@@ -5,7 +5,10 @@
 package terminal
 
 import (
+	"bytes"
 	"io"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -101,6 +104,156 @@ var keyPressTests = []struct {
 		"ab",
 		nil,
 	},
+	{
+		"abc\x01x\r", // Ctrl-A
+		"xabc",
+		nil,
+	},
+	{
+		"abc\x01\x05x\r", // Ctrl-A, Ctrl-E
+		"abcx",
+		nil,
+	},
+	{
+		"abc\x1b[Hx\x1b[Fy\r", // home, end
+		"xabcy",
+		nil,
+	},
+	{
+		"abc\x1b[1~x\x1b[4~y\r", // home, end of vt220
+		"xabcy",
+		nil,
+	},
+	{
+		"abc\x1bOHx\x1bOFy\r", // home, end of xterm application mode
+		"xabcy",
+		nil,
+	},
+	{
+		"ab\x02x\x06\x06y\r", // Ctrl-B, Ctrl-F
+		"axby",
+		nil,
+	},
+	{
+		"ab cd\x1bbx\x1bfy\r", // alt b, alt f
+		"ab xcdy",
+		nil,
+	},
+	{
+		"abcd\x1b[D\x1b[D\x0b\r", // (left, left) Ctrl-K
+		"ab",
+		nil,
+	},
+	{
+		"abcd\x1b[D\x15\r", // (left) Ctrl-U
+		"d",
+		nil,
+	},
+	{
+		"ab cd\x17\r", // Ctrl-W
+		"ab ",
+		nil,
+	},
+	{
+		"ab cd\x17\x17\x19\r", // Ctrl-W, Ctrl-W joined, Ctrl-Y
+		"ab cd",
+		nil,
+	},
+	{
+		"abc\x15x\x19\r", // Ctrl-U, Ctrl-Y
+		"xabc",
+		nil,
+	},
+	{
+		"ab\x15cd\x15\x19\x1by\r", // Ctrl-Y, alt y yanks the older kill
+		"ab",
+		nil,
+	},
+	{
+		"ab\x15cd\x15\x19\x1by\x1by\r", // alt y rotates the kill ring
+		"cd",
+		nil,
+	},
+	{
+		"ab\x1by\r", // alt y without yank
+		"ab",
+		nil,
+	},
+	{
+		"1+2\x0c\r", // Ctrl-L keeps the line
+		"1+2",
+		nil,
+	},
+	{
+		"abc\x1f\r", // Ctrl-_ undoes typed characters together
+		"",
+		nil,
+	},
+	{
+		"ab cd\x1b\x7f\x1f\r", // alt backspace, Ctrl-_
+		"ab cd",
+		nil,
+	},
+	{
+		"ab\x17cd\x1f\r", // Ctrl-W, typing, Ctrl-_
+		"",
+		nil,
+	},
+	{
+		"ab\x17cd\x1f\x1f\r", // Ctrl-_ twice
+		"ab",
+		nil,
+	},
+	{
+		"abc\x1b[D\x1b[Dx\x1f\r", // undo restores the cursor
+		"abc",
+		nil,
+	},
+	{
+		"ab\x1b[Dx\x1f\x1f\x1ay\r", // (left) x, undo twice, Ctrl-Z redo keeps the cursor of the undo
+		"ayb",
+		nil,
+	},
+	{
+		"abc\x1f\x1a\x1a\r", // redo without undo does nothing
+		"abc",
+		nil,
+	},
+	{
+		"abc\x1f\x1ax\x1a\r", // a change clears redo
+		"abcx",
+		nil,
+	},
+	{
+		"\x1f\x1a\r", // nothing to undo
+		"",
+		nil,
+	},
+	{
+		"2×π\r", // multi-byte characters, split by reads
+		"2×π",
+		nil,
+	},
+	{
+		"a÷計\x7f\x7fb\r", // backspace deletes characters, not bytes
+		"ab",
+		nil,
+	},
+	{
+		"√2\x1b[D\x1b[D−\x1b[C\x1b[C²\r", // (left) (left) − (right) (right) ²
+		"−√2²",
+		nil,
+	},
+	{
+		"ab×cd\x1b[D\x1b[D\x1b[D\x1b[3~\r", // delete ×
+		"abcd",
+		nil,
+	},
+	{
+		"a\u0080\u00a0b\r", // C1 controls aren't inserted
+		"a\u00a0b",
+		nil,
+	},
 }
 
 func TestKeyPresses(t *testing.T) {
@@ -123,3 +276,370 @@ func TestKeyPresses(t *testing.T) {
 		}
 	}
 }
+
+func TestSize(t *testing.T) {
+	c := &MockTerminal{}
+	ss := NewTerminal(c, "> ")
+	if width, height := ss.Size(); width != 80 || height != 24 {
+		t.Errorf("Default size was %dx%d, expected 80x24", width, height)
+	}
+	ss.SetSize(120, 40)
+	if width, height := ss.Size(); width != 120 || height != 40 {
+		t.Errorf("Size was %dx%d, expected 120x40", width, height)
+	}
+}
+
+func TestResizeRedraw(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("abcdefgh\x1b[D")}
+	ss := NewTerminal(c, "> ")
+	ss.SetSize(20, 24)
+	// the line stays being edited when the input ends
+	if _, err := ss.ReadLine(); err != io.EOF {
+		t.Fatalf("Reading line returned %v, expected EOF", err)
+	}
+	c.received = nil
+	ss.SetSize(4, 24)
+	// the cursor at column 9 is on the third row of 4 columns
+	if expected := "\x1b[A\x1b[A\r\x1b[J> abcdefgh"; !bytes.HasPrefix(c.received, []byte(expected)) {
+		t.Errorf("Line not redrawn, got %q, expected prefix %q", c.received, expected)
+	}
+	if ss.cursorX != 1 || ss.cursorY != 2 {
+		t.Errorf("Cursor after resize at %d,%d, expected 1,2", ss.cursorX, ss.cursorY)
+	}
+}
+
+func TestExtendedColors(t *testing.T) {
+	e := DefaultEscapeCodes()
+	tests := []struct {
+		got, expected string
+	}{
+		{string(e.Color256(214)), "\x1b[38;5;214m"},
+		{string(e.BgColor256(17)), "\x1b[48;5;17m"},
+		{string(e.RGB(255, 136, 0)), "\x1b[38;2;255;136;0m"},
+		{string(e.BgRGB(0, 0, 95)), "\x1b[48;2;0;0;95m"},
+		{string(e.BrightRed), "\x1b[91m"},
+	}
+	for i, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("Escape code %d was %q, expected %q", i, test.got, test.expected)
+		}
+	}
+
+	empty := &EscapeCodes{}
+	if code := empty.RGB(1, 2, 3); code != nil {
+		t.Errorf("Terminal without escape codes returned %q", code)
+	}
+}
+
+func TestHistorySize(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("1\r2\r3\r")}
+	ss := NewTerminal(c, "> ")
+	ss.SetHistorySize(2)
+	for i := 1; i <= 3; i++ {
+		if _, err := ss.ReadLine(); err != nil {
+			t.Fatalf("Reading line %d failed: %v", i, err)
+		}
+		ss.AddResultHistory("r")
+	}
+	history := ss.GetHistory()
+	if len(history) != 2 || history[0] != "2 = r" || history[1] != "3 = r" {
+		t.Errorf("History was %q, expected [\"2 = r\" \"3 = r\"]", history)
+	}
+}
+
+func TestKeyBinding(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("12\r\x10\r")}
+	ss := NewTerminal(c, "> ")
+	key, ok := KeyByName("ctrl-p")
+	if !ok || key != 0x10 {
+		t.Fatalf("KeyByName(ctrl-p) was %d, %v", key, ok)
+	}
+	ss.BindKey(key, KeyUp)
+	for i, expected := range []string{"12", "12"} {
+		line, err := ss.ReadLine()
+		if err != nil || line != expected {
+			t.Errorf("Line %d was %q (%v), expected %q", i, line, err, expected)
+		}
+	}
+	if _, ok := KeyByName("ctrl-"); ok {
+		t.Errorf("KeyByName accepted an incomplete name")
+	}
+}
+
+func TestSetHistory(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("\x1b[A\x1b[A\r3*3\r")}
+	ss := NewTerminal(c, "> ")
+	ss.SetHistory([]string{"1+1", "2+2"}, []string{"2", "4"})
+	line, err := ss.ReadLine()
+	if err != nil || line != "1+1" {
+		t.Errorf("Line after two ups was %q (%v), expected \"1+1\"", line, err)
+	}
+	ss.AddResultHistory("2")
+	if _, err := ss.ReadLine(); err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	ss.AddResultHistory("9")
+
+	history := ss.GetHistory()
+	expected := []string{"1+1 = 2", "2+2 = 4", "1+1 = 2", "3*3 = 9"}
+	if len(history) != len(expected) {
+		t.Fatalf("History was %q, expected %q", history, expected)
+	}
+	for i := range expected {
+		if history[i] != expected[i] {
+			t.Errorf("History line %d was %q, expected %q", i, history[i], expected[i])
+		}
+	}
+	lines, results := ss.NewHistory()
+	if len(lines) != 2 || lines[1] != "3*3" || results[1] != "9" {
+		t.Errorf("New history was %q = %q, expected the last two lines", lines, results)
+	}
+}
+
+var searchTests = []struct {
+	in   string
+	line string
+}{
+	{
+		"\x122\r", // Ctrl-R 2, Enter runs the match
+		"2+2",
+	},
+	{
+		"\x12+\x12\r", // Ctrl-R cycles to older matches
+		"2+2",
+	},
+	{
+		"\x12+\x12\x12\x12\r", // stays at the oldest match
+		"1+1",
+	},
+	{
+		"ab\x12zz\x07\r", // Ctrl-G aborts with the line before search
+		"ab",
+	},
+	{
+		"\x1223\x7f\r", // backspace shortens the failed query
+		"2+2",
+	},
+	{
+		"\x121+\x1b0\r", // Esc keeps the match for editing
+		"01+1",
+	},
+	{
+		"\x123\x1b[D\x1b[D9\r", // other keys end search and edit the match
+		"93+3",
+	},
+	{
+		"\x123\x1b[A\r", // history navigation continues from the match
+		"2+2",
+	},
+}
+
+func TestHistorySearch(t *testing.T) {
+	for i, test := range searchTests {
+		for j := 0; j < len(test.in); j++ {
+			c := &MockTerminal{
+				toSend:       []byte(test.in),
+				bytesPerRead: j,
+			}
+			ss := NewTerminal(c, "> ")
+			ss.SetHistory([]string{"1+1", "2+2", "3+3"}, nil)
+			line, err := ss.ReadLine()
+			if line != test.line || err != nil {
+				t.Errorf("Line resulting from test %d (%d bytes per read) was '%s' (%v), expected '%s'", i, j, line, err, test.line)
+				break
+			}
+		}
+	}
+}
+
+func TestHistorySearchPrompt(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("\x12x\r")}
+	ss := NewTerminal(c, "> ")
+	ss.SetHistory([]string{"1+1"}, nil)
+	if _, err := ss.ReadLine(); err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	if !bytes.Contains(c.received, []byte("(failed reverse-i-search)`x': ")) {
+		t.Errorf("Failed search prompt not written, got %q", c.received)
+	}
+	if ss.prompt != "> " {
+		t.Errorf("Prompt after search was %q, expected \"> \"", ss.prompt)
+	}
+}
+
+var viTests = []struct {
+	in   string
+	line string
+}{
+	{"abc\r", "abc"},                 // insert mode by default
+	{"abc\x1bhhix\r", "xabc"},        // Esc, h, h, insert
+	{"abc\x1b0ax\r", "axbc"},         // 0, append
+	{"abc\x1b0$ax\r", "abcx"},        // $, append
+	{"ab cd\x1b0wix\r", "ab xcd"},    // w
+	{"ab cd\x1bbix\r", "ab xcd"},     // b
+	{"ab cd\x1b0eax\r", "abx cd"},    // e
+	{"abc\x1b0x\r", "bc"},            // x
+	{"ab cd\x1b0dw\r", "cd"},         // dw
+	{"ab cd\x1bd0\r", "d"},           // d0
+	{"ab cd\x1b0d$\r", ""},           // d$
+	{"ab cd\x1bdd\r", ""},            // dd
+	{"ab cd\x1b0cwxy\r", "xy cd"},    // cw changes the word only
+	{"ab cd\x1b0Cxy\r", "xy"},        // C
+	{"ab cd\x1b0yw$p\r", "ab cdab "}, // yw, p
+	{"abc\x1b0xP\r", "abc"},          // x, P
+	{"ab cd\x1b0dwu\r", "ab cd"},     // u undoes the last change
+	{"ab cd\x1b0dwuu\r", ""},         // u again undoes typing
+	{"ab cd\x1b0dwu\x12\r", "cd"},    // Ctrl-R redoes
+	{"abc\x1b0iX\x1bu\r", "abc"},     // u undoes an insert
+	{"ab\x1bq\x1bix\r", "axb"},       // unknown keys are ignored
+	{"\x1bk\r", "2+2"},               // k, previous history line
+	{"\x1bkkj\r", "2+2"},             // k, k, j
+	{"abc\x1b\x1b[Dix\r", "axbc"},    // arrows work in normal mode
+	{"ab\x1b\x7f\x7fix\r", "xab"},    // backspace moves left
+}
+
+func TestViMode(t *testing.T) {
+	for i, test := range viTests {
+		for j := 0; j < len(test.in); j++ {
+			c := &MockTerminal{
+				toSend:       []byte(test.in),
+				bytesPerRead: j,
+			}
+			ss := NewTerminal(c, "> ")
+			ss.SetHistory([]string{"1+1", "2+2"}, nil)
+			ss.SetViMode(true)
+			line, err := ss.ReadLine()
+			if line != test.line || err != nil {
+				t.Errorf("Line resulting from test %d (%d bytes per read) was '%s' (%v), expected '%s'", i, j, line, err, test.line)
+				break
+			}
+		}
+	}
+}
+
+func TestShowCompletions(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("s\tin\r")}
+	ss := NewTerminal(c, "> ")
+	ss.AutoCompleteCallback = func(line []byte, pos, key int) ([]byte, int) {
+		if key == '\t' {
+			ss.ShowCompletions([]string{"sin", "sqrt", "sum"})
+		}
+		return nil, 0
+	}
+	line, err := ss.ReadLine()
+	if err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	if line != "sin" {
+		t.Errorf("Line was %q, expected \"sin\"", line)
+	}
+	if !bytes.Contains(c.received, []byte("\r\nsin   sqrt  sum\r\n")) {
+		t.Errorf("Candidates not written in columns, got %q", c.received)
+	}
+	if !bytes.Contains(c.received, []byte("\r\n\r\x1b[J> s")) {
+		t.Errorf("Prompt and line not redrawn, got %q", c.received)
+	}
+}
+
+func TestVisualLength(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+	}{
+		{"> ", 2},
+		{"\x1b[1m\x1b[33m icalc> \x1b[0m ", 9},
+		{"\x1b[38;5;214m1\x1b[0m+2", 3},
+		{"\x1b", 0},
+		{"2×π÷√3", 6},
+		{"計算", 4},
+		{"e\u0301", 1},
+	}
+	for _, test := range tests {
+		if width := visualLength([]rune(test.text)); width != test.width {
+			t.Errorf("Width of %q was %d, expected %d", test.text, width, test.width)
+		}
+	}
+}
+
+func TestHighlighter(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("12\x1b[D\r")}
+	ss := NewTerminal(c, "\x1b[1m>\x1b[0m ")
+	var cursors []int
+	ss.Highlighter = func(line []byte, cursor int) []byte {
+		cursors = append(cursors, cursor)
+		return []byte("\x1b[1m" + string(line) + "\x1b[0m")
+	}
+	line, err := ss.ReadLine()
+	if err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	if line != "12" {
+		t.Errorf("Line was %q, expected \"12\"", line)
+	}
+	if !bytes.Contains(c.received, []byte("\x1b[1m12\x1b[0m")) {
+		t.Errorf("Highlighted line not written, got %q", c.received)
+	}
+	if expected := []int{1, 2, 1, -1}; !reflect.DeepEqual(cursors, expected) {
+		t.Errorf("Highlighter was called with cursors %v, expected %v", cursors, expected)
+	}
+	// escape codes take no space, after the line the cursor goes back by
+	// one column to 1
+	if !bytes.Contains(c.received, []byte("\x1b[1m12\x1b[0m\x1b[D")) {
+		t.Errorf("Cursor not moved back by the visible width, got %q", c.received)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("1+2\r")}
+	ss := NewTerminal(c, "> ")
+	ss.PreviewCallback = func(line string) string {
+		if strings.HasSuffix(line, "+") {
+			return ""
+		}
+		return "= " + line
+	}
+	line, err := ss.ReadLine()
+	if err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	if line != "1+2" {
+		t.Errorf("Line was %q, expected \"1+2\"", line)
+	}
+	if !bytes.Contains(c.received, []byte("\r\n\x1b[K\x1b[2m= 1+2\x1b[0m\r\x1b[A")) {
+		t.Errorf("Preview not written below the line, got %q", c.received)
+	}
+	// the preview of 1 is removed while 1+ is incomplete
+	if !bytes.Contains(c.received, []byte("+\r\n\x1b[K\r\x1b[A")) {
+		t.Errorf("Preview not cleared, got %q", c.received)
+	}
+	if !bytes.HasSuffix(c.received, []byte("\x1b[J\r\n")) || ss.preview != "" {
+		t.Errorf("Preview not cleared by Enter, got %q", c.received)
+	}
+}
+
+func TestPreviewWithoutColors(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("1\r")}
+	ss := NewTerminal(c, "> ")
+	ss.Escape = &EscapeCodes{}
+	ss.PreviewCallback = func(line string) string {
+		return "= " + line
+	}
+	if _, err := ss.ReadLine(); err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	if !bytes.Contains(c.received, []byte("\r\n\x1b[K= 1\r\x1b[A")) {
+		t.Errorf("Preview not written plain, got %q", c.received)
+	}
+}
+
+func TestWideCursor(t *testing.T) {
+	c := &MockTerminal{toSend: []byte("計算\x1b[D\r")}
+	ss := NewTerminal(c, "\x1b[1m>\x1b[0m ")
+	if _, err := ss.ReadLine(); err != nil {
+		t.Fatalf("Reading line failed: %v", err)
+	}
+	// left moves over two columns of a wide character, the prompt takes two
+	if !bytes.Contains(c.received, []byte("計算\x1b[D\x1b[D\x1b[C\x1b[C")) {
+		t.Errorf("Cursor not moved by columns, got %q", c.received)
+	}
+}
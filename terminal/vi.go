@@ -0,0 +1,247 @@
+package terminal
+
+import "unicode"
+
+// viState is the state of vi editing mode.
+type viState struct {
+	// normal is true in normal mode, false in insert mode
+	normal bool
+	// op is the pending operator d, c or y waiting for a motion, 0 if none
+	op int
+	// register holds the text deleted or yanked last, put by p and P
+	register []rune
+}
+
+// SetViMode switches between vi and the default emacs editing mode. Lines
+// start in insert mode, Esc switches to normal mode.
+func (t *Terminal) SetViMode(on bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if on == (t.vi != nil) {
+		return
+	}
+	if on {
+		t.vi = &viState{}
+	} else {
+		t.vi = nil
+	}
+}
+
+// handleViKey processes key in vi mode, it returns false if the key must be
+// processed as in emacs mode, e.g. arrows, Enter or keys typed in insert mode.
+func (t *Terminal) handleViKey(key int) bool {
+	vi := t.vi
+	if !vi.normal {
+		// Esc followed quickly by b, f, y or backspace is read as an Alt key
+		switch key {
+		case KeyAltBackspace:
+			return t.handleViKey(KeyEscape) && t.handleViKey(KeyBackspace)
+		case KeyAltLeft:
+			return t.handleViKey(KeyEscape) && t.handleViKey('b')
+		case KeyAltRight:
+			return t.handleViKey(KeyEscape) && t.handleViKey('f')
+		case KeyAltY:
+			return t.handleViKey(KeyEscape) && t.handleViKey('y')
+		}
+		if key != KeyEscape {
+			return false
+		}
+		vi.normal = true
+		if t.pos > 0 {
+			t.pos--
+			t.moveCursorToPos(t.pos)
+		}
+		return true
+	}
+
+	switch {
+	case key == KeyEnter || key == KeyCtrlC:
+		// the next line starts in insert mode
+		vi.normal, vi.op = false, 0
+		return false
+	case key == KeyEscape:
+		vi.op = 0
+		return true
+	case key == KeyBackspace:
+		t.viMoveTo(t.viMotion('h'))
+		return true
+	case key == KeyCtrlR:
+		t.redo()
+		t.viMoveTo(t.pos)
+		return true
+	case !isPrintable(key):
+		vi.op = 0
+		return false
+	case vi.op != 0:
+		t.viOperator(key)
+		return true
+	}
+
+	switch key {
+	case 'h', 'l', '0', '$', 'w', 'b', 'e':
+		t.viMoveTo(t.viMotion(key))
+	case 'i':
+		t.viInsert(t.pos)
+	case 'a':
+		t.viInsert(min(t.pos+1, len(t.line)))
+	case 'I':
+		t.viInsert(0)
+	case 'A':
+		t.viInsert(len(t.line))
+	case 'x':
+		if len(t.line) > 0 {
+			t.viDelete(t.pos, t.pos+1)
+		}
+	case 'D', 'C':
+		vi.op = key + 'a' - 'A'
+		t.viOperator('$')
+	case 'd', 'c', 'y':
+		vi.op = key
+	case 'p', 'P':
+		if len(vi.register) == 0 {
+			break
+		}
+		pos := t.pos
+		if key == 'p' && len(t.line) > 0 {
+			pos++
+		}
+		line := append(append(append([]rune(nil), t.line[:pos]...), vi.register...), t.line[pos:]...)
+		t.redraw(t.prompt, line, pos+len(vi.register)-1)
+	case 'u':
+		t.undo()
+		t.viMoveTo(t.pos)
+	case 'j':
+		t.handleKey(KeyDown)
+		t.viMoveTo(0)
+	case 'k':
+		t.handleKey(KeyUp)
+		t.viMoveTo(0)
+	}
+	return true
+}
+
+// viMotion returns the position the motion key moves the cursor to.
+func (t *Terminal) viMotion(key int) int {
+	line, pos := t.line, t.pos
+	switch key {
+	case 'h':
+		return max(pos-1, 0)
+	case 'l':
+		return pos + 1
+	case '0':
+		return 0
+	case '$':
+		return len(line)
+	case 'w':
+		// start of the next word
+		if pos < len(line) {
+			class := charClass(line[pos])
+			for pos < len(line) && class != 0 && charClass(line[pos]) == class {
+				pos++
+			}
+		}
+		for pos < len(line) && charClass(line[pos]) == 0 {
+			pos++
+		}
+		return pos
+	case 'b':
+		// start of this or the previous word
+		for pos > 0 && charClass(line[pos-1]) == 0 {
+			pos--
+		}
+		if pos > 0 {
+			class := charClass(line[pos-1])
+			for pos > 0 && charClass(line[pos-1]) == class {
+				pos--
+			}
+		}
+		return pos
+	case 'e':
+		// end of this or the next word
+		pos++
+		for pos < len(line) && charClass(line[pos]) == 0 {
+			pos++
+		}
+		if pos < len(line) {
+			class := charClass(line[pos])
+			for pos+1 < len(line) && charClass(line[pos+1]) == class {
+				pos++
+			}
+		}
+		return pos
+	}
+	return pos
+}
+
+// charClass separates words of vi motions: 0 for spaces, 1 for letters,
+// digits and _, 2 for other characters.
+func charClass(c rune) int {
+	switch {
+	case unicode.IsSpace(c):
+		return 0
+	case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_':
+		return 1
+	}
+	return 2
+}
+
+// viMoveTo moves the cursor to pos, in normal mode it stays on a character.
+func (t *Terminal) viMoveTo(pos int) {
+	t.pos = max(min(pos, len(t.line)-1), 0)
+	t.moveCursorToPos(t.pos)
+}
+
+// viOperator applies the pending operator to the text from the cursor to
+// the position of motion key, the operator key again applies it to the
+// whole line, e.g. dd.
+func (t *Terminal) viOperator(key int) {
+	vi := t.vi
+	op := vi.op
+	vi.op = 0
+
+	from, to := t.pos, t.pos
+	switch {
+	case key == op:
+		from, to = 0, len(t.line)
+	case key == 'w' && op == 'c':
+		// cw changes to the end of the word like ce
+		to = t.viMotion('e') + 1
+	case key == 'e':
+		to = t.viMotion(key) + 1
+	case key == 'h' || key == 'l' || key == '0' || key == '$' || key == 'w' || key == 'b':
+		to = t.viMotion(key)
+	default:
+		return
+	}
+	if from > to {
+		from, to = to, from
+	}
+	to = min(to, len(t.line))
+
+	switch op {
+	case 'y':
+		vi.register = append([]rune(nil), t.line[from:to]...)
+		t.viMoveTo(from)
+	case 'd':
+		t.viDelete(from, to)
+	case 'c':
+		vi.register = append([]rune(nil), t.line[from:to]...)
+		t.deleteRange(from, to)
+		vi.normal = false
+	}
+}
+
+// viDelete deletes line[from:to] to the register.
+func (t *Terminal) viDelete(from, to int) {
+	t.vi.register = append([]rune(nil), t.line[from:to]...)
+	t.deleteRange(from, to)
+	t.viMoveTo(from)
+}
+
+// viInsert switches to insert mode at pos.
+func (t *Terminal) viInsert(pos int) {
+	t.vi.normal = false
+	t.pos = pos
+	t.moveCursorToPos(t.pos)
+}
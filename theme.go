@@ -0,0 +1,469 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"./terminal"
+)
+
+// color depths of terminals
+const (
+	colors16   = 16
+	colors256  = 256
+	colorsTrue = 1 << 24
+)
+
+// color depth of the terminal, detected by setupColors
+var colorDepth = colors16
+
+// names of the 16 basic colors in order of escape codes
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// color of a style, depth is 0 for the default color of the terminal
+type color struct {
+	depth   int
+	n       uint8
+	r, g, b uint8
+}
+
+// style of a theme element, e.g. "bold yellow on cyan", "214" or "#ff8800"
+type style struct {
+	bold   bool
+	fg, bg color
+}
+
+// colors of the REPL
+type theme struct {
+	prompt, result, error, operator, number, function style
+	// parentheses of input and the pair at the cursor
+	paren, match style
+}
+
+// built-in themes
+var themes = map[string]theme{
+	"dark": {
+		prompt:   mustStyle("bold yellow on cyan"),
+		result:   mustStyle("bold"),
+		error:    mustStyle("bold red"),
+		operator: mustStyle("cyan"),
+		number:   mustStyle("bright-white"),
+		function: mustStyle("green"),
+		paren:    mustStyle("yellow"),
+		match:    mustStyle("bold black on yellow"),
+	},
+	"light": {
+		prompt:   mustStyle("bold white on blue"),
+		result:   mustStyle("bold"),
+		error:    mustStyle("bold red"),
+		operator: mustStyle("blue"),
+		number:   mustStyle("magenta"),
+		function: mustStyle("green"),
+		paren:    mustStyle("red"),
+		match:    mustStyle("bold white on red"),
+	},
+	"monochrome": {
+		prompt: mustStyle("bold"),
+		result: mustStyle("bold"),
+		error:  mustStyle("bold"),
+		match:  mustStyle("bold"),
+	},
+}
+
+// name of the current theme and its styles with overrides of the config
+var (
+	themeName      = "dark"
+	currentTheme   = themes[themeName]
+	themeOverrides = map[string]style{}
+)
+
+func init() {
+	replCommands["theme"] = replCommand{themeCommand, ":theme [name]", "list themes or switch to theme name"}
+}
+
+// set theme by name from option or :theme, overrides of elements are kept
+func setTheme(name string) error {
+	t, ok := themes[name]
+	if !ok {
+		return setUsageError("unknown theme " + name + ", expected " + strings.Join(themeNames(), ", "))
+	}
+	themeName, currentTheme = name, t
+	for element, s := range themeOverrides {
+		*currentTheme.element(element) = s
+	}
+	return nil
+}
+
+// set style of a theme element from config, e.g. theme.prompt = bold red
+func setThemeStyle(element, spec string) error {
+	p := currentTheme.element(element)
+	if p == nil {
+		return setUsageError("unknown theme element " + element + ", expected prompt, result, error, operator, number, function, paren or match")
+	}
+	s, err := parseStyle(spec)
+	if err != nil {
+		return err
+	}
+	*p, themeOverrides[element] = s, s
+	return nil
+}
+
+// pointer to style of element or nil if unknown
+func (t *theme) element(name string) *style {
+	switch name {
+	case "prompt":
+		return &t.prompt
+	case "result":
+		return &t.result
+	case "error":
+		return &t.error
+	case "operator":
+		return &t.operator
+	case "number":
+		return &t.number
+	case "function":
+		return &t.function
+	case "paren":
+		return &t.paren
+	case "match":
+		return &t.match
+	}
+	return nil
+}
+
+func themeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// :theme lists themes, :theme light switches to one
+func themeCommand(args string, term *terminal.Terminal) (string, error) {
+	if args == "" {
+		lines := []string{"Themes:"}
+		for _, name := range themeNames() {
+			mark := "  "
+			if name == themeName {
+				mark = "* "
+			}
+			lines = append(lines, mark+name)
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	if err := setTheme(args); err != nil {
+		return "", err
+	}
+	term.SetPrompt(promptText())
+	return "Theme " + args, nil
+}
+
+// prompt of interactive mode in colors of the theme
+func promptText() string {
+	return outColors.paint(currentTheme.prompt, termText) + " "
+}
+
+func mustStyle(spec string) style {
+	s, err := parseStyle(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parse style of words: bold, foreground color and "on" background color;
+// colors are names of the 16 basic ones like red or bright-red, numbers of
+// the 256-color palette or #rrggbb
+func parseStyle(spec string) (style, error) {
+	var s style
+	words := strings.Fields(strings.ToLower(spec))
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case word == "bold":
+			s.bold = true
+		case word == "on":
+			if i+1 == len(words) {
+				return s, setUsageError("style " + spec + ": expected color after on")
+			}
+			i++
+			c, err := parseColor(words[i])
+			if err != nil {
+				return s, err
+			}
+			s.bg = c
+		default:
+			c, err := parseColor(word)
+			if err != nil {
+				return s, err
+			}
+			s.fg = c
+		}
+	}
+	return s, nil
+}
+
+func parseColor(word string) (color, error) {
+	name := strings.TrimPrefix(word, "bright-")
+	for i, c := range colorNames {
+		if c == name {
+			if name != word {
+				i += 8
+			}
+			return color{depth: colors16, n: uint8(i)}, nil
+		}
+	}
+	if n, err := strconv.ParseUint(word, 10, 8); err == nil {
+		return color{depth: colors256, n: uint8(n)}, nil
+	}
+	if len(word) == 7 && word[0] == '#' {
+		if rgb, err := strconv.ParseUint(word[1:], 16, 32); err == nil {
+			return color{depth: colorsTrue, r: uint8(rgb >> 16), g: uint8(rgb >> 8), b: uint8(rgb)}, nil
+		}
+	}
+	return color{}, setUsageError("unknown color " + word + ", expected a name like red, 0-255 or #rrggbb")
+}
+
+// color depth by COLORTERM and TERM
+func detectColorDepth() int {
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return colorsTrue
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return colors256
+	}
+	return colors16
+}
+
+// color reduced to depth of the terminal
+func (c color) reduce(depth int) color {
+	if c.depth == colorsTrue && depth < colorsTrue {
+		c = color{depth: colors256, n: rgbTo256(c.r, c.g, c.b)}
+	}
+	if c.depth == colors256 && depth < colors256 {
+		if c.n < 16 {
+			return color{depth: colors16, n: c.n}
+		}
+		r, g, b := rgbOf256(c.n)
+		c = color{depth: colors16, n: rgbTo16(r, g, b)}
+	}
+	return c
+}
+
+// levels of the 6x6x6 cube of the 256-color palette
+var cubeLevels = []uint8{0, 95, 135, 175, 215, 255}
+
+func rgbTo256(r, g, b uint8) uint8 {
+	level := func(x uint8) uint8 { return uint8((int(x)*5 + 127) / 255) }
+	return 16 + 36*level(r) + 6*level(g) + level(b)
+}
+
+// rgb of palette color n from 16, the cube or the gray ramp
+func rgbOf256(n uint8) (uint8, uint8, uint8) {
+	if n >= 232 {
+		gray := 8 + 10*(n-232)
+		return gray, gray, gray
+	}
+	n -= 16
+	return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+}
+
+// basic color with the channels above half, bright if one is close to full
+func rgbTo16(r, g, b uint8) uint8 {
+	var n uint8
+	for i, x := range []uint8{r, g, b} {
+		if x > 127 {
+			n |= 1 << uint(i)
+		}
+	}
+	if r > 191 || g > 191 || b > 191 {
+		n += 8
+	}
+	return n
+}
+
+// escape code of color in foreground or background
+func (r renderer) color(c color, background bool) []byte {
+	e := r.escape
+	c = c.reduce(colorDepth)
+	switch c.depth {
+	case colors16:
+		if background {
+			return [][]byte{e.BgBlack, e.BgRed, e.BgGreen, e.BgYellow, e.BgBlue, e.BgMagenta, e.BgCyan, e.BgWhite}[c.n%8]
+		}
+		return [][]byte{e.Black, e.Red, e.Green, e.Yellow, e.Blue, e.Magenta, e.Cyan, e.White,
+			e.BrightBlack, e.BrightRed, e.BrightGreen, e.BrightYellow, e.BrightBlue, e.BrightMagenta, e.BrightCyan, e.BrightWhite}[c.n]
+	case colors256:
+		if background {
+			return e.BgColor256(c.n)
+		}
+		return e.Color256(c.n)
+	case colorsTrue:
+		if background {
+			return e.BgRGB(c.r, c.g, c.b)
+		}
+		return e.RGB(c.r, c.g, c.b)
+	}
+	return nil
+}
+
+// text in style of a theme element
+func (r renderer) paint(s style, text string) string {
+	if r.escape == nil || text == "" {
+		return text
+	}
+	var code []byte
+	if s.bold {
+		code = append(code, r.escape.Bold...)
+	}
+	code = append(code, r.color(s.fg, false)...)
+	code = append(code, r.color(s.bg, true)...)
+	if len(code) == 0 {
+		return text
+	}
+	return r.style(code, text)
+}
+
+// expression with numbers, operators and functions in colors of the theme,
+// text after a symbol which can't be tokenized stays plain
+func highlight(r renderer, text string) string {
+	tokens, _ := tokenize(text)
+	res, end := "", 0
+	for _, t := range tokens {
+		if t.kind == tokenEOF {
+			break
+		}
+		res += text[end:t.pos]
+		end = t.end
+		switch t.kind {
+		case tokenNumber:
+			res += r.paint(currentTheme.number, text[t.pos:end])
+		case tokenOperator:
+			res += r.paint(currentTheme.operator, text[t.pos:end])
+		case tokenIdent:
+			if _, ok := functions[t.text]; ok {
+				res += r.paint(currentTheme.function, text[t.pos:end])
+			} else {
+				res += text[t.pos:end]
+			}
+		default:
+			res += text[t.pos:end]
+		}
+	}
+	return res + text[end:]
+}
+
+// input line of interactive mode in colors of the theme while typed: symbols
+// which aren't tokens and unmatched parentheses in error color, the
+// parenthesis at or before the cursor and its pair in match color; cursor is
+// -1 once the line is entered
+func highlightInput(r renderer, line []byte, cursor int) []byte {
+	text := string(line)
+	if r.escape == nil || strings.HasPrefix(text, ":") {
+		return line
+	}
+	styles := make([]*style, len(text))
+	pairs := map[int]int{}
+	var open []int
+	for offset := 0; offset < len(text); {
+		tokens, err := tokenize(text[offset:])
+		for _, t := range tokens {
+			pos := offset + t.pos
+			switch t.kind {
+			case tokenNumber:
+				for i := pos; i < offset+t.end; i++ {
+					styles[i] = &currentTheme.number
+				}
+			case tokenOperator:
+				for i := pos; i < offset+t.end; i++ {
+					styles[i] = &currentTheme.operator
+				}
+			case tokenIdent:
+				if isFunction(t.text) {
+					for i := pos; i < offset+t.end; i++ {
+						styles[i] = &currentTheme.function
+					}
+				}
+			case tokenOpen:
+				styles[pos] = &currentTheme.paren
+				open = append(open, pos)
+			case tokenClose:
+				if len(open) == 0 {
+					styles[pos] = &currentTheme.error
+					break
+				}
+				styles[pos] = &currentTheme.paren
+				pairs[pos], pairs[open[len(open)-1]] = open[len(open)-1], pos
+				open = open[:len(open)-1]
+			}
+		}
+		e, ok := err.(*calcError)
+		if !ok {
+			break
+		}
+		// strings of print, comparisons and assignments are valid lines
+		pos := offset + e.pos
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		switch c := text[pos]; {
+		case c == '"':
+			if end := strings.IndexByte(text[pos+1:], '"'); end >= 0 {
+				pos += end + 1
+			} else {
+				pos = len(text) - 1
+			}
+		case strings.IndexByte("=<>!", c) >= 0:
+			styles[pos] = &currentTheme.operator
+		default:
+			for i := pos; i < pos+size; i++ {
+				styles[i] = &currentTheme.error
+			}
+			pos += size - 1
+		}
+		offset = pos + 1
+	}
+	// unclosed parentheses are errors only once the line is entered
+	if cursor < 0 {
+		for _, pos := range open {
+			styles[pos] = &currentTheme.error
+		}
+	}
+	for _, pos := range []int{cursor, cursor - 1} {
+		if pair, ok := pairs[pos]; ok && pos >= 0 {
+			styles[pos], styles[pair] = &currentTheme.match, &currentTheme.match
+			break
+		}
+	}
+
+	var res strings.Builder
+	for start := 0; start < len(text); {
+		end := start + 1
+		for end < len(text) && styles[end] == styles[start] {
+			end++
+		}
+		if styles[start] == nil {
+			res.WriteString(text[start:end])
+		} else {
+			res.WriteString(r.paint(*styles[start], text[start:end]))
+		}
+		start = end
+	}
+	return []byte(res.String())
+}
+
+// name of a builtin, form, command or user function called with arguments
+func isFunction(name string) bool {
+	_, ok := functions[name]
+	if !ok {
+		_, ok = forms[name]
+	}
+	if !ok {
+		_, ok = argCommands[name]
+	}
+	return ok
+}
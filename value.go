@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// max bit length of an exact integer result, bigger results become floats
+const maxIntBits = 1 << 16
+
+// decimal places of float results, -1 for full precision
+var precision = -1
+
+const maxPrecision = 30
+
+// number formats of results
+const (
+	autoFormat  = "auto"
+	fixedFormat = "fixed"
+	sciFormat   = "sci"
+)
+
+// format of results: auto, fixed point or scientific
+var numberFormat = autoFormat
+
+// base of integer results: 2, 8, 10 or 16
+var numberBase = 10
+
+// prefixes of integers by base
+var basePrefixes = map[int]string{2: "0b", 8: "0o", 10: "", 16: "0x"}
+
+// Value is a result of evaluation. Integers are kept exact as big.Int,
+// everything else is a float64. expr is set for results which are better
+// shown as an expression (e.g. factor(360) = 2^3*3^2*5). Symbolic values
+// have no number, only an expression with unknowns (e.g. 3*x^2).
+// uncertainty is an error estimate of numeric methods like integrate.
+type Value struct {
+	i           *big.Int
+	f           float64
+	expr        Node
+	symbolic    bool
+	uncertainty float64
+}
+
+func intValue(i *big.Int) Value {
+	if i.BitLen() > maxIntBits {
+		f, _ := new(big.Float).SetInt(i).Float64()
+		return Value{f: f}
+	}
+	return Value{i: i}
+}
+
+func int64Value(i int64) Value {
+	return Value{i: big.NewInt(i)}
+}
+
+func floatValue(f float64) Value {
+	return Value{f: f}
+}
+
+func exprValue(n Node, v Value) Value {
+	v.expr = n
+	return v
+}
+
+func symbolicValue(n Node) Value {
+	return Value{expr: n, symbolic: true}
+}
+
+// convert value to expression tree
+func valueNode(v Value) Node {
+	if v.symbolic {
+		return v.expr
+	}
+	return &numberNode{value: v}
+}
+
+// IsInt reports whether v is an exact integer
+func (v Value) IsInt() bool {
+	return v.i != nil
+}
+
+// Float returns v as float64
+func (v Value) Float() float64 {
+	if v.i != nil {
+		f, _ := new(big.Float).SetInt(v.i).Float64()
+		return f
+	}
+	return v.f
+}
+
+// Int returns v as exact integer, ok is false if v has a fractional part
+func (v Value) Int() (*big.Int, bool) {
+	if v.i != nil {
+		return v.i, true
+	}
+	if math.IsInf(v.f, 0) || math.IsNaN(v.f) || v.f != math.Trunc(v.f) {
+		return nil, false
+	}
+	i, _ := big.NewFloat(v.f).Int(nil)
+	return i, true
+}
+
+func (v Value) String() string {
+	if v.expr != nil {
+		return v.expr.String()
+	}
+	if v.i != nil {
+		if numberFormat == sciFormat {
+			return new(big.Float).SetInt(v.i).Text('e', precision)
+		}
+		if v.i.Sign() < 0 {
+			return "-" + basePrefixes[numberBase] + new(big.Int).Neg(v.i).Text(numberBase)
+		}
+		return basePrefixes[numberBase] + v.i.Text(numberBase)
+	}
+	if math.IsInf(v.f, 0) || math.IsNaN(v.f) {
+		return fmt.Sprint(v.f)
+	}
+	switch {
+	case numberFormat == sciFormat:
+		return strconv.FormatFloat(v.f, 'e', precision, 64)
+	case numberFormat == fixedFormat || precision >= 0:
+		return strconv.FormatFloat(v.f, 'f', precision, 64)
+	}
+	return fmt.Sprint(v.f)
+}
+
+// set number format from option
+func setNumberFormat(format string) error {
+	switch format {
+	case autoFormat, fixedFormat, sciFormat:
+		numberFormat = format
+		return nil
+	}
+	return setUsageError("unknown number format " + format + ", expected auto, fixed or sci")
+}
+
+// set base of integer results from option
+func setNumberBase(base string) error {
+	n, err := strconv.Atoi(base)
+	if _, ok := basePrefixes[n]; err != nil || !ok {
+		return setUsageError("unsupported base " + base + ", expected 2, 8, 10 or 16")
+	}
+	numberBase = n
+	return nil
+}
+
+// arithmetic on values start
+func addValues(a, b Value) Value {
+	if a.IsInt() && b.IsInt() {
+		return intValue(new(big.Int).Add(a.i, b.i))
+	}
+	return floatValue(a.Float() + b.Float())
+}
+
+func subtractValues(a, b Value) Value {
+	if a.IsInt() && b.IsInt() {
+		return intValue(new(big.Int).Sub(a.i, b.i))
+	}
+	return floatValue(a.Float() - b.Float())
+}
+
+func multiplyValues(a, b Value) Value {
+	if a.IsInt() && b.IsInt() {
+		return intValue(new(big.Int).Mul(a.i, b.i))
+	}
+	return floatValue(a.Float() * b.Float())
+}
+
+func divideValues(a, b Value) (Value, error) {
+	if b.Float() == 0 {
+		return Value{}, setError("you tried to divide by zero")
+	}
+	if a.IsInt() && b.IsInt() {
+		q, r := new(big.Int).QuoRem(a.i, b.i, new(big.Int))
+		if r.Sign() == 0 {
+			return intValue(q), nil
+		}
+		f, _ := new(big.Rat).SetFrac(a.i, b.i).Float64()
+		return floatValue(f), nil
+	}
+	return floatValue(a.Float() / b.Float()), nil
+}
+
+func modValues(a, b Value) (Value, error) {
+	if b.Float() == 0 {
+		return Value{}, setError("Modulo by zero")
+	}
+	if a.IsInt() && b.IsInt() {
+		return intValue(new(big.Int).Rem(a.i, b.i)), nil
+	}
+	return floatValue(math.Mod(a.Float(), b.Float())), nil
+}
+
+func powValues(a, b Value) Value {
+	if a.IsInt() && b.IsInt() && b.i.Sign() >= 0 {
+		// least bit length of the result, bigger ones become floats in
+		// intValue, e.g. 2^65536
+		if b.i.IsInt64() && b.i.Int64() <= maxIntBits && int64(a.i.BitLen()-1)*b.i.Int64()+1 <= maxIntBits {
+			return intValue(new(big.Int).Exp(a.i, b.i, nil))
+		}
+		switch {
+		case a.i.Sign() == 0:
+			return int64Value(0)
+		case a.i.BitLen() == 1 && (a.i.Sign() > 0 || b.i.Bit(0) == 0):
+			return int64Value(1)
+		case a.i.BitLen() == 1:
+			return int64Value(-1)
+		}
+	}
+	return floatValue(math.Pow(a.Float(), b.Float()))
+}
+
+func negateValue(a Value) Value {
+	if a.IsInt() {
+		return intValue(new(big.Int).Neg(a.i))
+	}
+	return floatValue(-a.f)
+}
+
+// arithmetic on values end